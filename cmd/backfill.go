@@ -1,14 +1,31 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
 	"time"
 
 	"github.com/geoah/go-trade/market/gdax"
+	per "github.com/geoah/go-trade/persistence"
 	"github.com/spf13/cobra"
 )
 
 var (
-	backfillDays = 1
+	backfillDays  = 1
+	backfillCheck = false
+	backfillFrom  = ""
+	backfillTo    = ""
+)
+
+var (
+	// backfillTimeFormats are the accepted formats for --from and --to
+	backfillTimeFormats = []string{
+		time.RFC3339,
+		"2006-01-02",
+	}
 )
 
 // backfillCmd represents the backfill command
@@ -21,17 +38,102 @@ var backfillCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(backfillCmd)
 	backfillCmd.PersistentFlags().IntVar(&backfillDays, "days", 1, "Number of days to backfill")
+	backfillCmd.PersistentFlags().StringVar(&backfillFrom, "from", "", "Backfill from this time (RFC3339 or date), overrides --days")
+	backfillCmd.PersistentFlags().StringVar(&backfillTo, "to", "", "Backfill up to this time (RFC3339 or date), defaults to now")
+	backfillCmd.PersistentFlags().BoolVar(&backfillCheck, "check", false, "Report holes in the stored trades instead of backfilling")
 }
 
 func backfill(cmd *cobra.Command, args []string) {
 	var err error
 
+	end := time.Now()
+	if backfillTo != "" {
+		if end, err = parseBackfillTime(backfillTo); err != nil {
+			log.WithError(err).Fatalf("Could not parse --to")
+		}
+	}
+	start := end.Add(-24 * time.Duration(backfillDays) * time.Hour)
+	if backfillFrom != "" {
+		if start, err = parseBackfillTime(backfillFrom); err != nil {
+			log.WithError(err).Fatalf("Could not parse --from")
+		}
+	}
+	if !start.Before(end) {
+		log.Fatalf("Backfill start needs to be before its end")
+	}
+
+	if backfillCheck {
+		ranges, err := persistence.GetTradeRanges(gdax.Name, productName, start, end)
+		if err != nil {
+			log.WithError(err).Fatalf("Could not get trade ranges")
+		}
+		if err := printGaps(os.Stdout, ranges); err != nil {
+			log.WithError(err).Fatalf("Could not print gaps")
+		}
+		return
+	}
+
 	// setup gdax
-	market, err = gdax.New(persistence, productName)
+	market, err = gdax.New(persistence, productName, false)
 	if err != nil {
 		log.WithError(err).Fatalf("Could not create market")
 	}
 
 	// backfill market
-	market.Backfill(time.Now().Add(-24 * time.Duration(backfillDays) * time.Hour))
+	if err := market.Backfill(start, end); err != nil {
+		log.WithError(err).Fatalf("Could not backfill")
+	}
+}
+
+// parseBackfillTime parses a time in any of the backfill time formats, dates
+// are in UTC
+func parseBackfillTime(s string) (time.Time, error) {
+	for _, f := range backfillTimeFormats {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid time " + s + ", expected RFC3339 or a date")
+}
+
+// printGaps writes a summary of the stored trades and the holes between them
+func printGaps(w io.Writer, ranges []*per.TradeRange) error {
+	if len(ranges) == 0 {
+		fmt.Fprintln(w, "No trades stored, you might want to backfill first.")
+		return nil
+	}
+	total := 0
+	for _, r := range ranges {
+		total += r.Count()
+	}
+	first, last := ranges[0], ranges[len(ranges)-1]
+	fmt.Fprintf(
+		w,
+		"Have %d trades (%d to %d) from %s to %s\n",
+		total,
+		first.First,
+		last.Last,
+		first.Start.Format(time.RFC3339),
+		last.End.Format(time.RFC3339),
+	)
+	gaps := per.Gaps(ranges)
+	if len(gaps) == 0 {
+		fmt.Fprintln(w, "No holes found")
+		return nil
+	}
+	fmt.Fprintf(w, "Found %d holes\n\n", len(gaps))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "First\tLast\tMissing\tAfter\tBefore")
+	for _, g := range gaps {
+		fmt.Fprintf(
+			tw,
+			"%d\t%d\t%d\t%s\t%s\n",
+			g.First,
+			g.Last,
+			g.Count(),
+			g.Start.Format(time.RFC3339),
+			g.End.Format(time.RFC3339),
+		)
+	}
+	return tw.Flush()
 }
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	gdax "github.com/geoah/go-trade/market/gdax"
+	per "github.com/geoah/go-trade/persistence"
+	report "github.com/geoah/go-trade/report"
+)
+
+var (
+	optimizeEmaWindows         = []string{"2", "3", "5", "8", "13"}
+	optimizeAggregationPeriods = []string{"5m", "15m", "30m"}
+	optimizeAggregationVolumes = []string{}
+	optimizeSearch             = "grid"
+	optimizeSamples            = 20
+	optimizeObjective          = "return"
+	optimizeWorkers            = runtime.NumCPU()
+	optimizeTop                = 10
+	optimizeOutput             = ""
+	optimizeWalkForward        = false
+	optimizeInSample           = time.Duration(0)
+	optimizeOutOfSample        = time.Duration(0)
+)
+
+// optimizeCmd represents the optimize command
+var optimizeCmd = &cobra.Command{
+	Use:   "optimize",
+	Short: "Run simulations for a set of parameters and rank them",
+	Run:   optimize,
+}
+
+func init() {
+	RootCmd.AddCommand(optimizeCmd)
+	addSimFlags(optimizeCmd)
+	addOptimizeFlags(optimizeCmd)
+	optimizeCmd.Flags().IntVar(&optimizeTop, "top", 10, "Number of results to print")
+	optimizeCmd.Flags().StringVar(&optimizeOutput, "output", "", "Also write all results as json to the given file")
+	optimizeCmd.Flags().BoolVar(&optimizeWalkForward, "walk-forward", false, "Optimize on rolling in sample windows and evaluate on the out of sample windows that follow them")
+	optimizeCmd.Flags().DurationVar(&optimizeInSample, "in-sample", 0, "Length of the in sample windows for walk forward analysis, defaults to half of --last")
+	optimizeCmd.Flags().DurationVar(&optimizeOutOfSample, "out-of-sample", 0, "Length of the out of sample windows for walk forward analysis, windows roll forward by this much, defaults to an eighth of --last")
+}
+
+// addOptimizeFlags adds the flags that configure the parameter search
+func addOptimizeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&optimizeEmaWindows, "ema-windows", optimizeEmaWindows, "EMA windows to try")
+	cmd.Flags().StringSliceVar(&optimizeAggregationPeriods, "aggregation-periods", optimizeAggregationPeriods, "Time aggregation periods to try")
+	cmd.Flags().StringSliceVar(&optimizeAggregationVolumes, "aggregation-volumes", optimizeAggregationVolumes, "Volume aggregation limits to try")
+	cmd.Flags().StringVar(&optimizeSearch, "search", "grid", "How to pick parameters from the grid [grid/random]")
+	cmd.Flags().IntVar(&optimizeSamples, "samples", 20, "Number of parameter sets to try for random search")
+	cmd.Flags().StringVar(&optimizeObjective, "objective", "return", "What to rank results by [return/annualized/sharpe/sortino/drawdown/win-rate]")
+	cmd.Flags().IntVar(&optimizeWorkers, "workers", runtime.NumCPU(), "Number of simulations to run in parallel")
+}
+
+func optimize(cmd *cobra.Command, args []string) {
+	candidates, err := optimizeCandidates()
+	if err != nil {
+		log.WithError(err).Fatalf("Could not create parameters")
+	}
+	if _, err := objective(optimizeObjective, &report.Report{}); err != nil {
+		log.WithError(err).Fatalf("Could not rank results")
+	}
+
+	// load trades once and share them between all simulations
+	end := time.Now()
+	trades, err := persistence.GetTrades(gdax.Name, productName, end.Add(-simLast), end)
+	if err != nil {
+		log.WithError(err).Fatalf("Could not get trades")
+	}
+	if len(trades) == 0 {
+		log.Fatalf("No trades for the given duration, you might want to backfill first.")
+	}
+	cache, _ := per.NewMemory()
+	if err := cache.PutTrade(trades...); err != nil {
+		log.WithError(err).Fatalf("Could not cache trades")
+	}
+
+	log.
+		WithField("trades", len(trades)).
+		WithField("simulations", len(candidates)).
+		WithField("workers", optimizeWorkers).
+		Infof("Started optimizing")
+
+	if optimizeWalkForward {
+		walkForward(cache, end.Add(-simLast), end, candidates)
+		return
+	}
+
+	results := optimizeRun(cache, end.Add(-simLast), end, candidates)
+	rankResults(results, optimizeObjective)
+
+	if err := printResults(os.Stdout, results, optimizeTop); err != nil {
+		log.WithError(err).Errorf("Could not print results")
+	}
+	if optimizeOutput != "" {
+		bs, _ := json.MarshalIndent(results, "", "  ")
+		if err := ioutil.WriteFile(optimizeOutput, bs, 0644); err != nil {
+			log.WithError(err).Errorf("Could not write results")
+		}
+	}
+}
+
+// optimizeCandidates creates the parameter sets to try from the flags
+func optimizeCandidates() ([]simParams, error) {
+	emas, err := parseFloats(optimizeEmaWindows)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := parseFloats(optimizeAggregationVolumes)
+	if err != nil {
+		return nil, err
+	}
+	periods := []time.Duration{}
+	for _, s := range optimizeAggregationPeriods {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, d)
+	}
+
+	grid := []simParams{}
+	for _, ema := range emas {
+		for _, period := range periods {
+			grid = append(grid, simParams{
+				EmaWindow:         ema,
+				AggregationPeriod: period,
+			})
+		}
+		for _, volume := range volumes {
+			grid = append(grid, simParams{
+				EmaWindow:         ema,
+				AggregationVolume: volume,
+			})
+		}
+	}
+
+	switch optimizeSearch {
+	case "grid":
+		return grid, nil
+	case "random":
+		samples := []simParams{}
+		for _, i := range rand.Perm(len(grid)) {
+			if len(samples) >= optimizeSamples {
+				break
+			}
+			samples = append(samples, grid[i])
+		}
+		return samples, nil
+	}
+	return nil, fmt.Errorf("Unknown search %s", optimizeSearch)
+}
+
+func parseFloats(ss []string) ([]float64, error) {
+	fs := []float64{}
+	for _, s := range ss {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// optimizeRun simulates all parameter sets on the given trades between start
+// and end in parallel
+func optimizeRun(trades per.Persistence, start, end time.Time, candidates []simParams) []*simResult {
+	workers := optimizeWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan simParams)
+	results := []*simResult{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for params := range jobs {
+				res, err := simulate(trades, start, end, params)
+				if err != nil {
+					log.WithError(err).WithField("params", params).Warnf("Could not run simulation")
+					continue
+				}
+				lock.Lock()
+				results = append(results, res)
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, params := range candidates {
+		jobs <- params
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// objective returns the value of a report for the given objective, higher
+// values are better
+func objective(name string, rep *report.Report) (float64, error) {
+	switch name {
+	case "return":
+		return rep.Return, nil
+	case "annualized":
+		return rep.AnnualizedReturn, nil
+	case "sharpe":
+		return rep.Sharpe, nil
+	case "sortino":
+		return rep.Sortino, nil
+	case "drawdown":
+		return -rep.MaxDrawdown, nil
+	case "win-rate":
+		return rep.WinRate, nil
+	}
+	return 0, fmt.Errorf("Unknown objective %s", name)
+}
+
+// rankResults sorts results by the given objective, best first
+func rankResults(results []*simResult, name string) {
+	sort.SliceStable(results, func(i, j int) bool {
+		vi, _ := objective(name, results[i].Report)
+		vj, _ := objective(name, results[j].Report)
+		return vi > vj
+	})
+}
+
+// printResults writes the top results as a table
+func printResults(w io.Writer, results []*simResult, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tEMA\tPeriod\tVolume\tReturn\tDrawdown\tSharpe\tSortino\tWin rate\tRound trips\tFees")
+	for i, res := range results {
+		if i >= top {
+			break
+		}
+		rep := res.Report
+		fmt.Fprintf(
+			tw,
+			"%d\t%g\t%s\t%g\t%0.2f%%\t%0.2f%%\t%0.2f\t%0.2f\t%0.2f%%\t%d\t%0.2f\n",
+			i+1,
+			res.Params.EmaWindow,
+			res.Params.AggregationPeriod,
+			res.Params.AggregationVolume,
+			rep.Return*100,
+			rep.MaxDrawdown*100,
+			rep.Sharpe,
+			rep.Sortino,
+			rep.WinRate*100,
+			rep.RoundTrips,
+			rep.Fees,
+		)
+	}
+	return tw.Flush()
+}
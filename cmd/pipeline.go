@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	agr "github.com/geoah/go-trade/aggregator"
+	mrk "github.com/geoah/go-trade/market"
+	fake "github.com/geoah/go-trade/market/fake"
+	gdax "github.com/geoah/go-trade/market/gdax"
+	per "github.com/geoah/go-trade/persistence"
+	report "github.com/geoah/go-trade/report"
+	simple "github.com/geoah/go-trade/strategy/simple"
+	trd "github.com/geoah/go-trade/trader"
+)
+
+var (
+	simAssetCapital    = 0.0
+	simCurrencyCapital = 1000.0
+	simLast            = time.Hour
+	simFillModel       = fake.FillModelVolume
+	simFeeSchedule     = "gdax"
+	simFeeVolume       = 0.0
+	simFeeMarketVolume = 0.0
+	simFeeMaker        = 0.0
+	simFeeTaker        = 0.003
+	simSlippage        = fake.SlippageNone
+	simSlippageBps     = 0.0
+	simLatency         = time.Duration(0)
+	simLatencyJitter   = time.Duration(0)
+)
+
+// addSimFlags adds the flags that configure the simulated market
+func addSimFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64Var(&simAssetCapital, "asset_capital", 0.0, "Amount of start capital in asset")
+	cmd.Flags().Float64Var(&simCurrencyCapital, "currency_capital", 1000.0, "Amount of start capital in currency")
+	cmd.Flags().DurationVar(&simLast, "last", time.Hour, "Simulate the last hours/days/etc to sim. eg 1h")
+	cmd.Flags().StringVar(&simFillModel, "fill-model", fake.FillModelVolume, "How orders get filled [full/volume]")
+	cmd.Flags().StringVar(&simFeeSchedule, "fee-schedule", "gdax", "Fee schedule to use [gdax/flat]")
+	cmd.Flags().Float64Var(&simFeeVolume, "fee-volume", 0.0, "Trailing 30 day volume in currency used to pick the gdax fee tier")
+	cmd.Flags().Float64Var(&simFeeMarketVolume, "fee-market-volume", 0.0, "Trailing 30 day volume of the product in currency, gdax fee tiers are a share of it")
+	cmd.Flags().Float64Var(&simFeeMaker, "fee-maker", 0.0, "Maker fee rate for the flat fee schedule, eg 0.001 for 0.1%")
+	cmd.Flags().Float64Var(&simFeeTaker, "fee-taker", 0.003, "Taker fee rate for the flat fee schedule, eg 0.003 for 0.3%")
+	cmd.Flags().StringVar(&simSlippage, "slippage", fake.SlippageNone, "Slippage model [none/fixed/volume/spread]")
+	cmd.Flags().Float64Var(&simSlippageBps, "slippage-bps", 0.0, "Slippage in basis points for the fixed and volume models")
+	cmd.Flags().DurationVar(&simLatency, "latency", 0, "Time it takes for orders to reach the market, eg 250ms")
+	cmd.Flags().DurationVar(&simLatencyJitter, "latency-jitter", 0, "Random extra time it takes for orders to reach the market, eg 100ms")
+}
+
+// simParams are the strategy and aggregation parameters of a simulation
+type simParams struct {
+	EmaWindow float64 `json:"ema_window"`
+	// AggregationPeriod uses a time aggregator when set, else a volume
+	// aggregator with AggregationVolume is used
+	AggregationPeriod time.Duration `json:"aggregation_period"`
+	AggregationVolume float64       `json:"aggregation_volume"`
+}
+
+// simResult -
+type simResult struct {
+	Params   simParams        `json:"params"`
+	Trader   *trd.Trader      `json:"-"`
+	Recorder *report.Recorder `json:"-"`
+	Report   *report.Report   `json:"report"`
+}
+
+// newSimMarket creates a fake market based on the sim flags, every market
+// gets its own models as they keep track of the trades they see
+func newSimMarket(pe per.Persistence, start, end time.Time) (mrk.Market, error) {
+	fillModel, err := fake.NewFillModel(simFillModel)
+	if err != nil {
+		return nil, err
+	}
+
+	var fees mrk.FeeModel
+	switch simFeeSchedule {
+	case "gdax":
+		fees = gdax.Fees(productName, simFeeVolume, simFeeMarketVolume)
+	case "flat":
+		fees = mrk.NewFlatFees(simFeeMaker, simFeeTaker)
+	default:
+		return nil, errors.New("Unknown fee schedule " + simFeeSchedule)
+	}
+
+	slippage, err := fake.NewSlippageModel(simSlippage, simSlippageBps)
+	if err != nil {
+		return nil, err
+	}
+	latency := fake.NewRandomLatency(simLatency, simLatencyJitter)
+
+	return fake.New(pe, gdax.Name, productName, start, end, simAssetCapital, simCurrencyCapital, fillModel, fees, slippage, latency)
+}
+
+// newAggregator -
+func newAggregator(params simParams) (agr.Aggregator, error) {
+	if params.AggregationPeriod > 0 {
+		return agr.NewTimeAggregator(params.AggregationPeriod)
+	}
+	return agr.NewVolumeAggregator(params.AggregationVolume)
+}
+
+// simulate runs the sim pipeline of fake market, aggregator, trader, and
+// strategy on the trades between start and end in the given persistence
+func simulate(pe per.Persistence, start, end time.Time, params simParams) (*simResult, error) {
+	// setup strategy
+	str, err := simple.New(params.EmaWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup fake market
+	mkt, err := newSimMarket(pe, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup aggregator
+	agg, err := newAggregator(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// setup trader
+	trdr, err := trd.New(mkt, str, 8, 2) // TODO Get precision from market
+	if err != nil {
+		return nil, err
+	}
+
+	// setup recorder
+	rec := report.NewRecorder(mkt)
+
+	// attach handlers
+	mkt.RegisterForTrades(agg)
+	mkt.RegisterForUpdates(trdr)
+	mkt.RegisterForUpdates(rec)
+	agg.Register(trdr)
+	agg.Register(rec)
+
+	// start market
+	mkt.Run()
+
+	return &simResult{
+		Params:   params,
+		Trader:   trdr,
+		Recorder: rec,
+		Report:   report.Summarize(rec.Points, rec.Ledger),
+	}, nil
+}
@@ -26,6 +26,9 @@ var (
 	logLevel               string
 	emaWindow              float64
 	aggregationVolumeLimit float64
+	persistenceName        string
+	sqlitePath             string
+	archivePath            string
 
 	persistence per.Persistence
 	market      mrk.Market
@@ -63,6 +66,12 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&productName, "product", "BTC-USD", "product name")
 	RootCmd.PersistentFlags().Float64Var(&emaWindow, "ema-window", 3, "EMA window")
 	RootCmd.PersistentFlags().Float64Var(&aggregationVolumeLimit, "aggregation-volume", 0.5, "Volume aggregation")
+	RootCmd.PersistentFlags().StringVar(&persistenceName, "persistence", "rethinkdb", "Where to store trades [rethinkdb/sqlite/archive]")
+	RootCmd.PersistentFlags().StringVar(&sqlitePath, "sqlite-path", "go-trade.db", "Path of the sqlite database")
+	RootCmd.PersistentFlags().StringVar(&archivePath, "archive-path", "trades", "Directory of the gzipped csv trade archive")
+	viper.BindPFlag("persistence", RootCmd.PersistentFlags().Lookup("persistence"))
+	viper.BindPFlag("sqlite-path", RootCmd.PersistentFlags().Lookup("sqlite-path"))
+	viper.BindPFlag("archive-path", RootCmd.PersistentFlags().Lookup("archive-path"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -112,11 +121,27 @@ func setup() {
 
 	log = logrus.New()
 
+	switch viper.GetString("persistence") {
+	case "rethinkdb":
+		persistence, err = setupRethinkDB()
+	case "sqlite":
+		persistence, err = per.NewSQLite(viper.GetString("sqlite-path"))
+	case "archive":
+		persistence, err = per.NewArchive(viper.GetString("archive-path"))
+	default:
+		log.WithField("persistence", viper.GetString("persistence")).Fatalf("Unknown persistence")
+	}
+	if err != nil {
+		log.WithError(err).Fatalf("Could not create persistence")
+	}
+}
+
+func setupRethinkDB() (per.Persistence, error) {
 	rs, err := r.Connect(r.ConnectOpts{
 		Address: "localhost",
 	})
 	if err != nil {
-		log.WithError(err).Fatalf("Could not connect to rethinkdb")
+		return nil, err
 	}
 
 	rDB := "trade"
@@ -131,13 +156,21 @@ func setup() {
 	if err := r.DB(rDB).Table("trades").IndexCreate("time").Exec(rs); err != nil {
 		// log.WithError(err).Fatalf("Could not create rethinkdb index")
 	}
-	if err := r.DB(rDB).Table("trades").IndexWait().Exec(rs); err != nil {
-		log.WithError(err).Fatalf("Could not wait for indexes")
+	if err := r.DB(rDB).Table("trades").IndexCreate("trade_id").Exec(rs); err != nil {
+		// log.WithError(err).Fatalf("Could not create rethinkdb index")
 	}
-
-	persistence, err = per.NewRethinkDB(rs, rDB)
-	if err != nil {
-		log.WithError(err).Fatalf("Could not create rethinkdb persistence")
+	if err := r.DB(rDB).TableCreate("book_updates").Exec(rs); err != nil {
+		// log.WithError(err).Fatalf("Could not create rethinkdb table")
+	}
+	if err := r.DB(rDB).Table("book_updates").IndexCreate("time").Exec(rs); err != nil {
+		// log.WithError(err).Fatalf("Could not create rethinkdb index")
+	}
+	if err := r.DB(rDB).Table("book_updates").IndexWait().Exec(rs); err != nil {
+		return nil, err
+	}
+	if err := r.DB(rDB).Table("trades").IndexWait().Exec(rs); err != nil {
+		return nil, err
 	}
 
+	return per.NewRethinkDB(rs, rDB)
 }
@@ -3,22 +3,20 @@ package cmd
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"time"
 
 	"github.com/spf13/cobra"
 
-	agr "github.com/geoah/go-trade/aggregator"
 	mrk "github.com/geoah/go-trade/market"
-	fake "github.com/geoah/go-trade/market/fake"
-	gdax "github.com/geoah/go-trade/market/gdax"
-	simple "github.com/geoah/go-trade/strategy/simple"
-	trd "github.com/geoah/go-trade/trader"
+	report "github.com/geoah/go-trade/report"
 )
 
 var (
-	simAssetCapital    = 0.0
-	simCurrencyCapital = 1000.0
-	simLast            = time.Hour
+	simAggregationPeriod = 15 * time.Minute
+	simReport            = ""
+	simEquity            = ""
+	simLedger            = ""
 )
 
 // simCmd represents the sim command
@@ -30,67 +28,77 @@ var simCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(simCmd)
-	simCmd.Flags().Float64Var(&simAssetCapital, "asset_capital", 0.0, "Amount of start capital in asset")
-	simCmd.Flags().Float64Var(&simCurrencyCapital, "currency_capital", 1000.0, "Amount of start capital in currency")
-	simCmd.Flags().DurationVar(&simLast, "last", time.Hour, "Simulate the last hours/days/etc to sim. eg 1h")
+	addSimFlags(simCmd)
+	simCmd.Flags().DurationVar(&simAggregationPeriod, "aggregation-period", 15*time.Minute, "Time aggregation period, set to 0 to aggregate on volume instead")
+	simCmd.Flags().StringVar(&simReport, "report", "", "Also write the simulation report as json to the given file")
+	simCmd.Flags().StringVar(&simEquity, "equity", "", "Write the equity curve to the given .csv or .json file")
+	simCmd.Flags().StringVar(&simLedger, "ledger", "", "Write the ledger of placed, filled, and cancelled orders to the given .csv or .json file")
 }
 
 func sim(cmd *cobra.Command, args []string) {
-	var err error
-
-	// setup strategy
-	strategy, err = simple.New(emaWindow)
-	if err != nil {
-		log.WithError(err).Fatalf("Could not setup strategy")
+	params := simParams{
+		EmaWindow:         emaWindow,
+		AggregationPeriod: simAggregationPeriod,
+		AggregationVolume: aggregationVolumeLimit,
 	}
 
-	// setup fake market
-	market, err = fake.New(persistence, gdax.Name, productName, simLast, simAssetCapital, simCurrencyCapital)
 	log.
 		WithField("balance-assets", simAssetCapital).
 		WithField("balance-currency", simCurrencyCapital).
-		Info("Started market")
-
-	// setup aggregator
-	aggregator, err := agr.NewTimeAggregator(15 * time.Minute)
-	// aggregator, err := agr.NewVolumeAggregator(aggregationVolumeLimit)
-	if err != nil {
-		log.WithError(err).Fatalf("Could not setup aggregator")
-	}
+		WithField("fill-model", simFillModel).
+		WithField("fee-schedule", simFeeSchedule).
+		WithField("slippage", simSlippage).
+		WithField("latency", simLatency).
+		WithField("ema-window", params.EmaWindow).
+		WithField("aggregation-period", params.AggregationPeriod).
+		Infof("Started trading")
 
-	// setup trader
-	trader, err = trd.New(market, strategy, 8, 2) // TODO Get precision from market
+	// run simulation
+	end := time.Now()
+	res, err := simulate(persistence, end.Add(-simLast), end, params)
 	if err != nil {
-		log.WithError(err).Fatalf("Could not setup trader")
+		log.WithError(err).Fatalf("Could not run simulation")
 	}
-
-	// attach handlers
-	market.RegisterForTrades(aggregator)
-	market.RegisterForUpdates(trader)
-	aggregator.Register(trader)
-
-	log.
-		WithField("ema-window", emaWindow).
-		// WithField("aggregation-volume-limit", aggregationVolumeLimit).
-		Infof("Started trading")
-
-	// start market
-	market.Run()
+	trader = res.Trader
+	recorder := res.Recorder
 
 	// print data
-	now := time.Now()
 	data := []*mrk.Candle{}
 	for i, c := range trader.Candles {
 		if c.Ema > 0 && i > 10 {
-			c.Time = now
-			now = now.Add(5 * time.Minute)
 			data = append(data, c)
 		}
 	}
 	bs, _ := json.Marshal(data)
 	ioutil.WriteFile("data-sim.json", bs, 0644)
 
-	log.Warnf("Completed simulation with %d actions", trader.Trades)
+	log.
+		WithField("fees", trader.Fees).
+		Warnf("Completed simulation with %d actions", trader.Trades)
+
+	// print report
+	rep := res.Report
+	if err := rep.Print(os.Stdout); err != nil {
+		log.WithError(err).Errorf("Could not print report")
+	}
+	if simReport != "" {
+		bs, _ := json.MarshalIndent(rep, "", "  ")
+		if err := ioutil.WriteFile(simReport, bs, 0644); err != nil {
+			log.WithError(err).Errorf("Could not write report")
+		}
+	}
+
+	// export equity curve and ledger
+	if simEquity != "" {
+		if err := report.SaveEquity(simEquity, recorder.Points); err != nil {
+			log.WithError(err).Errorf("Could not write equity curve")
+		}
+	}
+	if simLedger != "" {
+		if err := report.SaveLedger(simLedger, recorder.Ledger); err != nil {
+			log.WithError(err).Errorf("Could not write ledger")
+		}
+	}
 
 	// print data
 	// data := make([][]interface{}, len(trader.Candles))
@@ -16,6 +16,10 @@ import (
 	trd "github.com/geoah/go-trade/trader"
 )
 
+var (
+	tradeRecordBook = false
+)
+
 // tradeCmd represents the trade command
 var tradeCmd = &cobra.Command{
 	Use:   "trade",
@@ -25,6 +29,7 @@ var tradeCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(tradeCmd)
+	tradeCmd.Flags().BoolVar(&tradeRecordBook, "record-book", false, "Persist the level2 book updates so simulations can replay them")
 	// tradeCmd.PersistentFlags().String("foo", "", "A help for foo")
 	// tradeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
@@ -39,13 +44,15 @@ func trade(cmd *cobra.Command, args []string) {
 	}
 
 	// setup fake market
-	market, err = gdax.New(persistence, productName)
+	market, err = gdax.New(persistence, productName, tradeRecordBook)
 	if ast, cur, err := market.GetBalance(); err != nil {
 		log.WithError(err).Fatalf("Could not get first time balance")
 	} else {
 		log.
-			WithField("balance-assets", ast).
-			WithField("balance-currency", cur).
+			WithField("balance-assets", ast.Total()).
+			WithField("balance-currency", cur.Total()).
+			WithField("available-assets", ast.Available).
+			WithField("available-currency", cur.Available).
 			Info("Started market")
 	}
 
@@ -79,18 +86,17 @@ func trade(cmd *cobra.Command, args []string) {
 	go func() {
 		for sig := range c {
 			log.WithField("sig", sig).Infof("Interrupted")
-			now := time.Now()
 			data := []*mrk.Candle{}
 			for i, c := range trader.Candles {
 				if c.Ema > 0 && i > 10 {
-					c.Time = now
-					now = now.Add(5 * time.Minute)
 					data = append(data, c)
 				}
 			}
 			bs, _ := json.Marshal(data)
 			ioutil.WriteFile("data-trade-"+started.Format("2006-01-02T15:04:05Z")+".json", bs, 0644)
-			log.Warnf("Completed trade with %d actions", trader.Trades)
+			log.
+				WithField("fees", trader.Fees).
+				Warnf("Completed trade with %d actions", trader.Trades)
 			os.Exit(0)
 		}
 	}()
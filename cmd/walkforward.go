@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	per "github.com/geoah/go-trade/persistence"
+	report "github.com/geoah/go-trade/report"
+)
+
+// walkForwardWindow is a single in sample optimization and the out of sample
+// evaluation of its best parameters
+type walkForwardWindow struct {
+	InSampleStart  time.Time      `json:"in_sample_start"`
+	OutSampleStart time.Time      `json:"out_of_sample_start"`
+	OutSampleEnd   time.Time      `json:"out_of_sample_end"`
+	Params         simParams      `json:"params"`
+	InSample       *report.Report `json:"in_sample"`
+	OutOfSample    *report.Report `json:"out_of_sample"`
+
+	result *simResult
+}
+
+// walkForwardResult -
+type walkForwardResult struct {
+	Windows     []*walkForwardWindow `json:"windows"`
+	OutOfSample *report.Report       `json:"out_of_sample"`
+}
+
+// walkForward splits the trades between start and end into rolling windows,
+// optimizes the parameters on each in sample window, and evaluates the best
+// ones on the out of sample window right after it.
+// Every out of sample simulation starts with the configured capital, and
+// their equity curves are stitched together for the overall report.
+// Windows that are not set are derived from the simulated period, so that it
+// fits five out of sample windows.
+func walkForward(trades per.Persistence, start, end time.Time, candidates []simParams) {
+	if optimizeInSample == 0 {
+		optimizeInSample = end.Sub(start) / 2
+	}
+	if optimizeOutOfSample == 0 {
+		optimizeOutOfSample = end.Sub(start) / 8
+	}
+	if optimizeInSample <= 0 || optimizeOutOfSample <= 0 {
+		log.Fatalf("In and out of sample windows need to be positive")
+	}
+
+	windows := []*walkForwardWindow{}
+	for ins := start; !ins.Add(optimizeInSample).Add(optimizeOutOfSample).After(end); ins = ins.Add(optimizeOutOfSample) {
+		outs := ins.Add(optimizeInSample)
+		oute := outs.Add(optimizeOutOfSample)
+		wlog := log.
+			WithField("in-sample", ins.Format(time.RFC3339)).
+			WithField("out-of-sample", outs.Format(time.RFC3339))
+
+		// optimize in sample
+		results := optimizeRun(trades, ins, outs, candidates)
+		if len(results) == 0 {
+			wlog.Warnf("No results for window")
+			continue
+		}
+		rankResults(results, optimizeObjective)
+		best := results[0]
+
+		// evaluate out of sample
+		res, err := simulate(trades, outs, oute, best.Params)
+		if err != nil {
+			wlog.WithError(err).Warnf("Could not run out of sample simulation")
+			continue
+		}
+		windows = append(windows, &walkForwardWindow{
+			InSampleStart:  ins,
+			OutSampleStart: outs,
+			OutSampleEnd:   oute,
+			Params:         best.Params,
+			InSample:       best.Report,
+			OutOfSample:    res.Report,
+			result:         res,
+		})
+		wlog.
+			WithField("ema-window", best.Params.EmaWindow).
+			WithField("in-sample-return", best.Report.Return).
+			WithField("out-of-sample-return", res.Report.Return).
+			Infof("Completed window")
+	}
+	if len(windows) == 0 {
+		log.Fatalf("Not enough trades for a single walk forward window")
+	}
+
+	// stitch out of sample results
+	recorders := []*report.Recorder{}
+	for _, w := range windows {
+		recorders = append(recorders, w.result.Recorder)
+	}
+	points, ledger := report.Stitch(recorders...)
+	wf := &walkForwardResult{
+		Windows:     windows,
+		OutOfSample: report.Summarize(points, ledger),
+	}
+
+	if err := printWalkForward(os.Stdout, wf); err != nil {
+		log.WithError(err).Errorf("Could not print results")
+	}
+	if optimizeOutput != "" {
+		bs, _ := json.MarshalIndent(wf, "", "  ")
+		if err := ioutil.WriteFile(optimizeOutput, bs, 0644); err != nil {
+			log.WithError(err).Errorf("Could not write results")
+		}
+	}
+}
+
+// printWalkForward writes the windows and the stitched out of sample report
+func printWalkForward(w io.Writer, wf *walkForwardResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Out of sample\tEMA\tPeriod\tVolume\tIn sample return\tOut of sample return\tDrawdown")
+	for _, win := range wf.Windows {
+		fmt.Fprintf(
+			tw,
+			"%s\t%g\t%s\t%g\t%0.2f%%\t%0.2f%%\t%0.2f%%\n",
+			win.OutSampleStart.Format(time.RFC3339),
+			win.Params.EmaWindow,
+			win.Params.AggregationPeriod,
+			win.Params.AggregationVolume,
+			win.InSample.Return*100,
+			win.OutOfSample.Return*100,
+			win.OutOfSample.MaxDrawdown*100,
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	return wf.OutOfSample.Print(w)
+}
@@ -0,0 +1,189 @@
+package market
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Balance of a currency, Hold is reserved by our open orders
+type Balance struct {
+	Currency  string  `json:"currency"`
+	Available float64 `json:"available"`
+	Hold      float64 `json:"hold"`
+}
+
+// Total -
+func (b Balance) Total() float64 {
+	return b.Available + b.Hold
+}
+
+// Account -
+type Account interface {
+	// Balance returns the balance of a currency, which is empty if we
+	// don't have any
+	Balance(currency string) Balance
+	// Balances returns the balances of all currencies we have, sorted by
+	// currency
+	Balances() []Balance
+}
+
+// hold is what an open order reserves
+type hold struct {
+	currency string
+	amount   float64
+}
+
+// NewPortfolio creates an empty portfolio that trades asset for currency
+func NewPortfolio(asset, currency string) *Portfolio {
+	return &Portfolio{
+		asset:    asset,
+		currency: currency,
+		balances: map[string]*Balance{},
+		holds:    map[string]*hold{},
+	}
+}
+
+// Portfolio is an Account that follows our balances from the updates of our
+// orders. Orders hold the funds they might need when they are opened, fills
+// move funds between the asset and the currency, and whatever is still held
+// is released once an order is done. As updates can be missed it should be
+// periodically reset from the exchange with Set.
+type Portfolio struct {
+	sync.RWMutex
+	asset    string
+	currency string
+	balances map[string]*Balance
+	// holds of open orders by order id
+	holds map[string]*hold
+}
+
+// Set replaces the balances with the ones the exchange reports, the holds of
+// the orders we know about are kept as they are part of the reported holds
+func (p *Portfolio) Set(balances ...Balance) {
+	p.Lock()
+	defer p.Unlock()
+	p.balances = map[string]*Balance{}
+	for _, b := range balances {
+		b := b
+		p.balances[b.Currency] = &b
+	}
+}
+
+// Balance -
+func (p *Portfolio) Balance(currency string) Balance {
+	p.RLock()
+	defer p.RUnlock()
+	if b, ok := p.balances[currency]; ok {
+		return *b
+	}
+	return Balance{
+		Currency: currency,
+	}
+}
+
+// Balances -
+func (p *Portfolio) Balances() []Balance {
+	p.RLock()
+	defer p.RUnlock()
+	balances := []Balance{}
+	for _, b := range p.balances {
+		balances = append(balances, *b)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency < balances[j].Currency
+	})
+	return balances
+}
+
+// Held returns what an order is still holding
+func (p *Portfolio) Held(orderID string) float64 {
+	p.RLock()
+	defer p.RUnlock()
+	if h, ok := p.holds[orderID]; ok {
+		return h.amount
+	}
+	return 0
+}
+
+// HandleUpdate implements UpdateHandler
+func (p *Portfolio) HandleUpdate(update *Update) error {
+	if update.Order == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	ord := update.Order
+	switch update.Action {
+	case Open, Activate:
+		if _, ok := p.holds[ord.ID]; !ok {
+			p.hold(ord)
+		}
+	case Buy:
+		p.release(ord.ID, update.Size*holdPrice(ord))
+		p.balance(p.currency).Available -= update.Size*update.Price + update.Fee
+		p.balance(p.asset).Available += update.Size
+	case Sell:
+		p.release(ord.ID, update.Size)
+		p.balance(p.asset).Available -= update.Size
+		p.balance(p.currency).Available += update.Size*update.Price - update.Fee
+	}
+	if ord.Done() {
+		p.release(ord.ID, math.Inf(1))
+		delete(p.holds, ord.ID)
+	}
+	return nil
+}
+
+// hold reserves what is left of an order, must be called while holding the
+// lock. Buys hold currency at their limit or stop price, market buys don't
+// have a price so they can't hold anything, and sells hold the asset.
+func (p *Portfolio) hold(ord *Order) {
+	h := &hold{
+		currency: p.asset,
+		amount:   ord.Remaining(),
+	}
+	if ord.Side == Buy {
+		h.currency = p.currency
+		h.amount = ord.Remaining() * holdPrice(ord)
+	}
+	p.holds[ord.ID] = h
+	b := p.balance(h.currency)
+	b.Available -= h.amount
+	b.Hold += h.amount
+}
+
+// release gives back up to amount of an order's hold, must be called while
+// holding the lock
+func (p *Portfolio) release(orderID string, amount float64) {
+	h, ok := p.holds[orderID]
+	if !ok {
+		return
+	}
+	amount = math.Min(amount, h.amount)
+	h.amount -= amount
+	b := p.balance(h.currency)
+	b.Available += amount
+	b.Hold = math.Max(0, b.Hold-amount)
+}
+
+// balance returns the balance of a currency, creating it if needed, must be
+// called while holding the lock
+func (p *Portfolio) balance(currency string) *Balance {
+	b, ok := p.balances[currency]
+	if !ok {
+		b = &Balance{
+			Currency: currency,
+		}
+		p.balances[currency] = b
+	}
+	return b
+}
+
+// holdPrice is the price an order's hold is based on
+func holdPrice(ord *Order) float64 {
+	if ord.Price > 0 {
+		return ord.Price
+	}
+	return ord.StopPrice
+}
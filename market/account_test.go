@@ -0,0 +1,142 @@
+package market
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPortfolioHandleUpdate(t *testing.T) {
+	type step struct {
+		action Action
+		fill   float64
+		price  float64
+		fee    float64
+	}
+	tests := []struct {
+		name  string
+		side  Action
+		steps []step
+		usd   Balance
+		btc   Balance
+		held  float64
+	}{
+		{
+			name:  "buy opened",
+			side:  Buy,
+			steps: []step{{action: Open}},
+			usd:   Balance{Currency: "USD", Available: 900, Hold: 100},
+			btc:   Balance{Currency: "BTC", Available: 1},
+			held:  100,
+		},
+		{
+			name:  "buy activated without being opened",
+			side:  Buy,
+			steps: []step{{action: Activate}},
+			usd:   Balance{Currency: "USD", Available: 900, Hold: 100},
+			btc:   Balance{Currency: "BTC", Available: 1},
+			held:  100,
+		},
+		{
+			name:  "buy opened and activated holds once",
+			side:  Buy,
+			steps: []step{{action: Open}, {action: Activate}},
+			usd:   Balance{Currency: "USD", Available: 900, Hold: 100},
+			btc:   Balance{Currency: "BTC", Available: 1},
+			held:  100,
+		},
+		{
+			name:  "buy partially filled",
+			side:  Buy,
+			steps: []step{{action: Open}, {action: Buy, fill: 0.4, price: 100, fee: 0.1}},
+			usd:   Balance{Currency: "USD", Available: 899.9, Hold: 60},
+			btc:   Balance{Currency: "BTC", Available: 1.4},
+			held:  60,
+		},
+		{
+			name:  "buy filled below its price",
+			side:  Buy,
+			steps: []step{{action: Open}, {action: Buy, fill: 1, price: 90, fee: 0.1}},
+			usd:   Balance{Currency: "USD", Available: 909.9},
+			btc:   Balance{Currency: "BTC", Available: 2},
+		},
+		{
+			name:  "buy fee correction",
+			side:  Buy,
+			steps: []step{{action: Open}, {action: Buy, fill: 0.4, price: 100, fee: 0.1}, {action: Buy, price: 100, fee: 0.05}},
+			usd:   Balance{Currency: "USD", Available: 899.85, Hold: 60},
+			btc:   Balance{Currency: "BTC", Available: 1.4},
+			held:  60,
+		},
+		{
+			name:  "buy cancelled",
+			side:  Buy,
+			steps: []step{{action: Open}, {action: Buy, fill: 0.4, price: 100}, {action: Cancel}},
+			usd:   Balance{Currency: "USD", Available: 960},
+			btc:   Balance{Currency: "BTC", Available: 1.4},
+		},
+		{
+			name:  "sell opened",
+			side:  Sell,
+			steps: []step{{action: Open}},
+			usd:   Balance{Currency: "USD", Available: 1000},
+			btc:   Balance{Currency: "BTC", Hold: 1},
+			held:  1,
+		},
+		{
+			name:  "sell filled",
+			side:  Sell,
+			steps: []step{{action: Open}, {action: Sell, fill: 1, price: 110, fee: 0.1}},
+			usd:   Balance{Currency: "USD", Available: 1109.9},
+			btc:   Balance{Currency: "BTC"},
+		},
+	}
+	for _, test := range tests {
+		p := NewPortfolio("BTC", "USD")
+		p.Set(Balance{Currency: "USD", Available: 1000}, Balance{Currency: "BTC", Available: 1})
+		ord := NewOrder("1", NewPostOnlyRequest(test.side, 1, 100), time.Time{})
+		for _, s := range test.steps {
+			var upd *Update
+			switch s.action {
+			case Open:
+				upd = ord.Update(s.action, ord.Price, ord.Size, time.Time{})
+			case Activate:
+				if err := ord.Open(); err != nil {
+					t.Fatalf("%s: %v", test.name, err)
+				}
+				upd = ord.Update(s.action, ord.Price, ord.Remaining(), time.Time{})
+			case Buy, Sell:
+				if s.fill > 0 {
+					if err := ord.Fill(s.fill, s.price, s.fee); err != nil {
+						t.Fatalf("%s: %v", test.name, err)
+					}
+				}
+				upd = ord.Update(s.action, s.price, s.fill, time.Time{})
+				upd.Fee = s.fee
+			case Cancel:
+				if err := ord.Cancel(); err != nil {
+					t.Fatalf("%s: %v", test.name, err)
+				}
+				upd = ord.Update(s.action, ord.Price, ord.Remaining(), time.Time{})
+			}
+			if err := p.HandleUpdate(upd); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if usd := p.Balance("USD"); !sameBalance(usd, test.usd) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.usd, usd)
+		}
+		if btc := p.Balance("BTC"); !sameBalance(btc, test.btc) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.btc, btc)
+		}
+		if held := p.Held("1"); math.Abs(held-test.held) > 1e-9 {
+			t.Errorf("%s: expected %v held, got %v", test.name, test.held, held)
+		}
+	}
+}
+
+func sameBalance(a, b Balance) bool {
+	return a.Currency == b.Currency &&
+		math.Abs(a.Available-b.Available) < 1e-9 &&
+		math.Abs(a.Hold-b.Hold) < 1e-9
+}
@@ -12,4 +12,8 @@ const (
 	Sell Action = "SELL"
 	// Cancel -
 	Cancel Action = "CANCEL"
+	// Open -
+	Open Action = "OPEN"
+	// Activate -
+	Activate Action = "ACTIVATE"
 )
@@ -0,0 +1,183 @@
+package market
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BookSnapshot replaces the whole book
+	BookSnapshot = "snapshot"
+	// BookChange sets the size of some levels
+	BookChange = "change"
+)
+
+// BookLevel is the total size resting at a price
+type BookLevel struct {
+	Price float64 `json:"price" gorethink:"price"`
+	Size  float64 `json:"size" gorethink:"size"`
+}
+
+// BookUpdate is a snapshot of, or a change to a level book, changed levels
+// with a size of zero are removed from the book. Sequence orders updates
+// with the same time, in the order they were received.
+type BookUpdate struct {
+	Market   string      `json:"-" gorethink:"market"`
+	Product  string      `json:"-" gorethink:"product"`
+	Type     string      `json:"type" gorethink:"type"`
+	Time     time.Time   `json:"time" gorethink:"time"`
+	Sequence int64       `json:"sequence" gorethink:"sequence"`
+	Bids     []BookLevel `json:"bids" gorethink:"bids"`
+	Asks     []BookLevel `json:"asks" gorethink:"asks"`
+}
+
+// Apply the update to a book
+func (u *BookUpdate) Apply(b *Book) {
+	if u.Type == BookSnapshot {
+		b.Snapshot(u.Bids, u.Asks)
+		return
+	}
+	for _, l := range u.Bids {
+		b.Update(Buy, l.Price, l.Size)
+	}
+	for _, l := range u.Asks {
+		b.Update(Sell, l.Price, l.Size)
+	}
+}
+
+// OrderBook -
+type OrderBook interface {
+	// BestBid returns the highest bid, false if there are no bids
+	BestBid() (BookLevel, bool)
+	// BestAsk returns the lowest ask, false if there are no asks
+	BestAsk() (BookLevel, bool)
+	// Depth returns up to n levels from the top of each side
+	Depth(n int) (bids, asks []BookLevel)
+	// Spread returns the difference between the best ask and bid, false if
+	// either side is empty
+	Spread() (float64, bool)
+}
+
+// QueuePositioner is implemented by markets that can estimate how much size
+// is resting ahead of our orders at their price
+type QueuePositioner interface {
+	QueuePosition(orderID string) (float64, bool)
+}
+
+// NewBook creates an empty price level book
+func NewBook() *Book {
+	return &Book{
+		bids: []BookLevel{},
+		asks: []BookLevel{},
+	}
+}
+
+// Book is an OrderBook that keeps the size at each price level, bids sorted
+// from highest to lowest price and asks from lowest to highest
+type Book struct {
+	sync.RWMutex
+	bids []BookLevel
+	asks []BookLevel
+}
+
+// Snapshot replaces the whole book
+func (b *Book) Snapshot(bids, asks []BookLevel) {
+	b.Lock()
+	defer b.Unlock()
+	b.bids = []BookLevel{}
+	b.asks = []BookLevel{}
+	for _, l := range bids {
+		b.bids = setLevel(b.bids, l, true)
+	}
+	for _, l := range asks {
+		b.asks = setLevel(b.asks, l, false)
+	}
+}
+
+// Update sets the size of a price level on the given side, a size of zero
+// removes the level
+func (b *Book) Update(side Action, price, size float64) {
+	b.Lock()
+	defer b.Unlock()
+	l := BookLevel{
+		Price: price,
+		Size:  size,
+	}
+	switch side {
+	case Buy:
+		b.bids = setLevel(b.bids, l, true)
+	case Sell:
+		b.asks = setLevel(b.asks, l, false)
+	}
+}
+
+// setLevel inserts, replaces, or removes a level keeping the levels sorted
+func setLevel(levels []BookLevel, l BookLevel, desc bool) []BookLevel {
+	i := sort.Search(len(levels), func(i int) bool {
+		if desc {
+			return levels[i].Price <= l.Price
+		}
+		return levels[i].Price >= l.Price
+	})
+	exists := i < len(levels) && levels[i].Price == l.Price
+	switch {
+	case exists && l.Size <= 0:
+		return append(levels[:i], levels[i+1:]...)
+	case exists:
+		levels[i] = l
+		return levels
+	case l.Size <= 0:
+		return levels
+	}
+	levels = append(levels, BookLevel{})
+	copy(levels[i+1:], levels[i:])
+	levels[i] = l
+	return levels
+}
+
+// BestBid -
+func (b *Book) BestBid() (BookLevel, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	if len(b.bids) == 0 {
+		return BookLevel{}, false
+	}
+	return b.bids[0], true
+}
+
+// BestAsk -
+func (b *Book) BestAsk() (BookLevel, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	if len(b.asks) == 0 {
+		return BookLevel{}, false
+	}
+	return b.asks[0], true
+}
+
+// Depth -
+func (b *Book) Depth(n int) (bids, asks []BookLevel) {
+	b.RLock()
+	defer b.RUnlock()
+	return topLevels(b.bids, n), topLevels(b.asks, n)
+}
+
+func topLevels(levels []BookLevel, n int) []BookLevel {
+	if n > len(levels) || n < 0 {
+		n = len(levels)
+	}
+	top := make([]BookLevel, n)
+	copy(top, levels[:n])
+	return top
+}
+
+// Spread -
+func (b *Book) Spread() (float64, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	if len(b.bids) == 0 || len(b.asks) == 0 {
+		return 0, false
+	}
+	return b.asks[0].Price - b.bids[0].Price, true
+}
@@ -0,0 +1,78 @@
+package market
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBookApply(t *testing.T) {
+	snapshot := &BookUpdate{
+		Type: BookSnapshot,
+		Bids: []BookLevel{{Price: 99, Size: 1}, {Price: 100, Size: 2}, {Price: 98, Size: 0}},
+		Asks: []BookLevel{{Price: 102, Size: 1}, {Price: 101, Size: 3}},
+	}
+	tests := []struct {
+		name    string
+		updates []*BookUpdate
+		bids    []BookLevel
+		asks    []BookLevel
+	}{
+		{
+			name:    "snapshot sorts levels and drops empty ones",
+			updates: []*BookUpdate{snapshot},
+			bids:    []BookLevel{{Price: 100, Size: 2}, {Price: 99, Size: 1}},
+			asks:    []BookLevel{{Price: 101, Size: 3}, {Price: 102, Size: 1}},
+		},
+		{
+			name: "change inserts, replaces, and removes levels",
+			updates: []*BookUpdate{snapshot, {
+				Type: BookChange,
+				Bids: []BookLevel{{Price: 99.5, Size: 1}, {Price: 100, Size: 0}},
+				Asks: []BookLevel{{Price: 102, Size: 5}, {Price: 103, Size: 0}},
+			}},
+			bids: []BookLevel{{Price: 99.5, Size: 1}, {Price: 99, Size: 1}},
+			asks: []BookLevel{{Price: 101, Size: 3}, {Price: 102, Size: 5}},
+		},
+		{
+			name: "snapshot replaces the book",
+			updates: []*BookUpdate{snapshot, {
+				Type: BookSnapshot,
+				Bids: []BookLevel{{Price: 50, Size: 1}},
+				Asks: []BookLevel{},
+			}},
+			bids: []BookLevel{{Price: 50, Size: 1}},
+			asks: []BookLevel{},
+		},
+	}
+	for _, test := range tests {
+		b := NewBook()
+		for _, u := range test.updates {
+			u.Apply(b)
+		}
+		bids, asks := b.Depth(-1)
+		if !reflect.DeepEqual(bids, test.bids) {
+			t.Errorf("%s: expected bids %v, got %v", test.name, test.bids, bids)
+		}
+		if !reflect.DeepEqual(asks, test.asks) {
+			t.Errorf("%s: expected asks %v, got %v", test.name, test.asks, asks)
+		}
+	}
+}
+
+func TestBookTop(t *testing.T) {
+	b := NewBook()
+	if _, ok := b.Spread(); ok {
+		t.Errorf("expected no spread on an empty book")
+	}
+	b.Update(Buy, 100, 1)
+	b.Update(Sell, 101.5, 1)
+	if bid, ok := b.BestBid(); !ok || bid.Price != 100 {
+		t.Errorf("expected a best bid of 100, got %v", bid)
+	}
+	if ask, ok := b.BestAsk(); !ok || ask.Price != 101.5 {
+		t.Errorf("expected a best ask of 101.5, got %v", ask)
+	}
+	if spread, ok := b.Spread(); !ok || spread != 1.5 {
+		t.Errorf("expected a spread of 1.5, got %v", spread)
+	}
+}
@@ -0,0 +1,171 @@
+package fake
+
+import (
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	// dust is the smallest size we consider worth filling
+	dust = 1e-8
+)
+
+// order is an order in the fake market's book
+type order struct {
+	id          string
+	clientID    string
+	side        market.Action
+	typ         market.OrderType
+	price       float64
+	stopPrice   float64
+	size        float64
+	postOnly    bool
+	timeInForce market.TimeInForce
+	created     time.Time
+	expires     time.Time
+	// pending orders have not reached the market yet
+	pending bool
+	// triggered stop orders behave like market or limit orders
+	triggered bool
+	// taker orders cross the spread when they become active, and take
+	// liquidity from the first trade they see
+	taker bool
+	// state tracks the order's status and fills
+	state *market.Order
+}
+
+// newOrder creates an order that reaches the market at the given replay time,
+// the request should already be valid
+func newOrder(id string, req market.OrderRequest, now time.Time) *order {
+	o := &order{
+		id:          id,
+		clientID:    req.ClientOrderID,
+		side:        req.Side,
+		typ:         req.Type,
+		price:       req.Price,
+		stopPrice:   req.StopPrice,
+		size:        req.Size,
+		postOnly:    req.PostOnly,
+		timeInForce: req.TimeInForce,
+		created:     now,
+		state:       market.NewOrder(id, req, now),
+	}
+	if req.TimeInForce == market.GoodTillTime {
+		switch req.CancelAfter {
+		case "min":
+			o.expires = now.Add(time.Minute)
+		case "hour":
+			o.expires = now.Add(time.Hour)
+		case "day":
+			o.expires = now.Add(24 * time.Hour)
+		}
+	}
+	return o
+}
+
+// handle returns a copy of the order's state
+func (o *order) handle() *market.Order {
+	state := *o.state
+	return &state
+}
+
+// stop checks if this is a stop or stop limit order
+func (o *order) stop() bool {
+	return o.typ == market.StopOrder || o.typ == market.StopLimitOrder
+}
+
+// market checks if the order fills at any price
+func (o *order) market() bool {
+	return o.typ == market.MarketOrder || o.typ == market.StopOrder
+}
+
+// immediate checks if the order should not outlive the first trade it sees
+func (o *order) immediate() bool {
+	return o.timeInForce == market.ImmediateOrCancel || o.timeInForce == market.FillOrKill
+}
+
+// triggeredBy checks if a trade went through the stop price
+func (o *order) triggeredBy(trade *market.Trade) bool {
+	switch o.side {
+	case market.Buy:
+		return trade.Price >= o.stopPrice
+	case market.Sell:
+		return trade.Price <= o.stopPrice
+	}
+	return false
+}
+
+// activate is called when the order reaches the book, or when a stop order
+// is triggered, and figures out if it is going to take liquidity
+func (o *order) activate(lastPrice float64) {
+	o.taker = o.market() || o.immediate() || o.wouldTake(lastPrice)
+	o.state.Open() // TODO Handle error
+}
+
+// update creates an update for the order's last transition
+func (o *order) update(action market.Action, price, size float64, at time.Time) *market.Update {
+	return o.state.Update(action, price, size, at)
+}
+
+// remaining returns the size that has not been filled yet
+func (o *order) remaining() float64 {
+	return o.state.Remaining()
+}
+
+// done checks if the order has been completely filled, ignoring any dust left
+// over by floating point arithmetic
+func (o *order) done() bool {
+	return o.remaining() < dust
+}
+
+// expired checks if a GTT order should have been cancelled by the given time
+func (o *order) expired(now time.Time) bool {
+	if o.expires.IsZero() {
+		return false
+	}
+	return !now.Before(o.expires)
+}
+
+// crossedBy checks if a trade went through the order's price.
+// Trades at exactly our price do not fill us as we have no idea where we are
+// in the queue, so we only fill when the market trades beyond our price.
+// Takers fill against any trade at their price or better.
+func (o *order) crossedBy(trade *market.Trade) bool {
+	if o.taker {
+		switch {
+		case o.market():
+			return true
+		case o.side == market.Buy:
+			return trade.Price <= o.price
+		case o.side == market.Sell:
+			return trade.Price >= o.price
+		}
+		return false
+	}
+	switch o.side {
+	case market.Buy:
+		return trade.Price < o.price
+	case market.Sell:
+		return trade.Price > o.price
+	}
+	return false
+}
+
+// wouldTake checks if the order would immediately match against the market
+// given the last traded price, which would make a post only order be rejected
+func (o *order) wouldTake(lastPrice float64) bool {
+	if o.market() {
+		return true
+	}
+	if lastPrice == 0 {
+		return false
+	}
+	switch o.side {
+	case market.Buy:
+		return o.price > lastPrice
+	case market.Sell:
+		return o.price < lastPrice
+	}
+	return false
+}
@@ -1,11 +1,16 @@
 package fake
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
+	uuid "github.com/google/uuid"
+
 	market "github.com/geoah/go-trade/market"
 	persistence "github.com/geoah/go-trade/persistence"
 	"github.com/geoah/go-trade/utils"
@@ -16,29 +21,65 @@ const (
 	Name = "fake"
 )
 
+var (
+	ErrorOrderRejected = errors.New("Order rejected")
+	ErrorOrderNotFound = errors.New("Order not found")
+)
+
 type Fake struct {
 	sync.Mutex
-	persistence persistence.Persistence
-	handlers    []market.TradeHandler
-	asset       float64
-	currency    float64
-	back        time.Duration
+	persistence    persistence.Persistence
+	handlers       []market.TradeHandler
+	updateHandlers []market.UpdateHandler
+	// portfolio holds our balances and what our orders are holding
+	portfolio   *market.Portfolio
+	start       time.Time
+	end         time.Time
 	marketName  string
 	productName string
-	feesPercent float64
+	fees        market.FeeModel
+	fillModel   FillModel
+	slippage    SlippageModel
+	latency     LatencyModel
+
+	// orders resting in the book, waiting for trades to cross them
+	orders []*order
+	// history holds every order we have placed by id
+	history map[string]*order
+	// now is the time of the last replayed trade
+	now time.Time
+	// lastPrice is the price of the last replayed trade
+	lastPrice float64
+	// book is a top of book approximation from the replayed trades
+	book *market.Book
 }
 
-func New(pe persistence.Persistence, mrk, prd string, back time.Duration, asset, currency float64) (market.Market, error) {
+// New creates a fake market that replays the trades between start and end
+func New(pe persistence.Persistence, mrk, prd string, start, end time.Time, asset, currency float64, fillModel FillModel, fees market.FeeModel, slippage SlippageModel, latency LatencyModel) (market.Market, error) {
 	m := &Fake{
 		handlers:    []market.TradeHandler{},
-		asset:       asset,
-		currency:    currency,
 		persistence: pe,
-		back:        back,
+		start:       start,
+		end:         end,
 		marketName:  mrk,
 		productName: prd,
-		feesPercent: 1.0,
+		fees:        fees,
+		fillModel:   fillModel,
+		slippage:    slippage,
+		latency:     latency,
+		orders:      []*order{},
+		history:     map[string]*order{},
+		book:        market.NewBook(),
 	}
+	ast, cur := currencies(prd)
+	m.portfolio = market.NewPortfolio(ast, cur)
+	m.portfolio.Set(market.Balance{
+		Currency:  ast,
+		Available: asset,
+	}, market.Balance{
+		Currency:  cur,
+		Available: currency,
+	})
 	return m, nil
 }
 
@@ -47,68 +88,467 @@ func (m *Fake) RegisterForTrades(handler market.TradeHandler) {
 }
 
 func (m *Fake) RegisterForUpdates(handler market.UpdateHandler) {
+	m.updateHandlers = append(m.updateHandlers, handler)
 }
 
+// Buy -
 func (m *Fake) Buy(quantity, price float64) error {
+	_, err := m.PlaceOrder(market.NewPostOnlyRequest(market.Buy, quantity, price))
+	return err
+}
+
+// Sell -
+func (m *Fake) Sell(quantity, price float64) error {
+	_, err := m.PlaceOrder(market.NewPostOnlyRequest(market.Sell, quantity, price))
+	return err
+}
+
+// PlaceOrder places an order that reaches the book after the latency model's
+// delay and gets filled by the trades that follow it
+func (m *Fake) PlaceOrder(req market.OrderRequest) (*market.Order, error) {
+	ord, upd, err := m.open(req)
+	if err != nil {
+		return nil, err
+	}
+	logrus.
+		WithField("type", req.Type).
+		WithField("price", utils.TrimFloat64(req.Price, 2)).
+		WithField("size", utils.TrimFloat64(req.Size, 8)).
+		Infof("Placed %s order", strings.ToLower(string(req.Side)))
+	m.notify([]*market.Update{upd})
+	return ord, nil
+}
+
+// open checks our balances and places an order, the returned update needs to
+// be sent out after the lock has been released
+func (m *Fake) open(req market.OrderRequest) (*market.Order, *market.Update, error) {
+	if err := req.Validate(); err != nil {
+		return nil, nil, err
+	}
 	m.Lock()
 	defer m.Unlock()
-	logrus.
-		WithField("price", utils.TrimFloat64(price, 2)).
-		WithField("size", utils.TrimFloat64(quantity, 8)).
-		Infof("Placed buy order")
-	cost := quantity * price * m.feesPercent // TODO Check fees
-	if cost > m.currency {
-		return errors.New("Not enough currency")
-	}
-	m.currency -= cost
-	m.asset += quantity
+	// market orders are checked against the last price, and stop orders
+	// against their stop price
+	price := req.Price
+	switch req.Type {
+	case market.MarketOrder:
+		price = m.lastPrice
+	case market.StopOrder:
+		price = req.StopPrice
+	}
+	liquidity := market.Taker
+	if req.PostOnly {
+		liquidity = market.Maker
+	}
+	// funds held by our other open orders can't be used
+	ast, cur := currencies(m.productName)
+	switch req.Side {
+	case market.Buy:
+		cost := req.Size * price * (1 + m.fees.Rate(liquidity, m.now))
+		if cost > m.portfolio.Balance(cur).Available {
+			return nil, nil, errors.New("Not enough currency")
+		}
+	case market.Sell:
+		if req.Size > m.portfolio.Balance(ast).Available {
+			return nil, nil, errors.New("Not enough assets")
+		}
+	}
+	o := newOrder(uuid.New().String(), req, m.arrival())
+	if err := m.place(o); err != nil {
+		return nil, nil, err
+	}
+	m.history[o.id] = o
+	upd := o.update(market.Open, o.price, o.size, m.now)
+	m.portfolio.HandleUpdate(upd) // TODO Handle error
+	return o.handle(), upd, nil
+}
+
+// CancelOrder removes an open order from the book
+func (m *Fake) CancelOrder(id string) error {
+	m.Lock()
+	upds := m.cancel(func(o *order) bool {
+		return o.id == id
+	})
+	m.Unlock()
+	if len(upds) == 0 {
+		return ErrorOrderNotFound
+	}
+	m.notify(upds)
 	return nil
 }
 
-func (m *Fake) Sell(quantity, price float64) error {
+// AmendOrder cancels an open order and places one with the same terms but
+// the given size and price, which goes through the latency model again
+func (m *Fake) AmendOrder(id string, size, price float64) (*market.Order, error) {
 	m.Lock()
-	defer m.Unlock()
-	logrus.
-		WithField("price", utils.TrimFloat64(price, 2)).
-		WithField("size", utils.TrimFloat64(quantity, 8)).
-		Infof("Placed sell order")
-	if quantity > m.asset {
-		return errors.New("Not enough assets")
-	}
-	m.asset -= quantity
-	m.currency += quantity * price / m.feesPercent // TODO Check fees
+	var req market.OrderRequest
+	found := false
+	for _, o := range m.orders {
+		if o.id == id {
+			req = o.state.AmendRequest(size, price)
+			found = true
+		}
+	}
+	if !found {
+		m.Unlock()
+		return nil, ErrorOrderNotFound
+	}
+	if err := req.Validate(); err != nil {
+		m.Unlock()
+		return nil, err
+	}
+	upds := m.cancel(func(o *order) bool {
+		return o.id == id
+	})
+	m.Unlock()
+	m.notify(upds)
+	return m.PlaceOrder(req)
+}
+
+// CancelAll removes all open orders from the book
+func (m *Fake) CancelAll() error {
+	m.Lock()
+	upds := m.cancel(func(o *order) bool {
+		return true
+	})
+	m.Unlock()
+	m.notify(upds)
 	return nil
 }
 
-func (m *Fake) GetBalance() (assets float64, currency float64, err error) {
+// cancel removes the matching open orders, must be called while holding the
+// lock, the returned updates need to be sent out after it has been released
+func (m *Fake) cancel(match func(o *order) bool) []*market.Update {
+	upds := []*market.Update{}
+	open := []*order{}
+	for _, o := range m.orders {
+		if !match(o) {
+			open = append(open, o)
+			continue
+		}
+		o.state.Cancel() // TODO Handle error
+		upd := o.update(market.Cancel, o.price, o.remaining(), m.now)
+		m.portfolio.HandleUpdate(upd) // TODO Handle error
+		upds = append(upds, upd)
+	}
+	m.orders = open
+	return upds
+}
+
+// GetOpenOrders -
+func (m *Fake) GetOpenOrders() ([]*market.Order, error) {
+	m.Lock()
+	defer m.Unlock()
+	orders := []*market.Order{}
+	for _, o := range m.orders {
+		orders = append(orders, o.handle())
+	}
+	return orders, nil
+}
+
+// GetOrder returns any order we have placed, open or not
+func (m *Fake) GetOrder(id string) (*market.Order, error) {
 	m.Lock()
 	defer m.Unlock()
-	return m.asset, m.currency, nil
+	o, ok := m.history[id]
+	if !ok {
+		return nil, ErrorOrderNotFound
+	}
+	return o.handle(), nil
+}
+
+// arrival returns the replay time an order placed now will reach the market
+func (m *Fake) arrival() time.Time {
+	return m.now.Add(m.latency.Delay())
+}
+
+// place adds an order to the book, must be called while holding the lock.
+// Orders that are delayed are only checked once they reach the market, and
+// rejections are reported as cancel updates.
+func (m *Fake) place(o *order) error {
+	if o.created.After(m.now) {
+		o.pending = true
+		m.orders = append(m.orders, o)
+		return nil
+	}
+	if o.postOnly && o.wouldTake(m.lastPrice) {
+		return ErrorOrderRejected
+	}
+	if !o.stop() {
+		o.activate(m.lastPrice)
+	}
+	m.orders = append(m.orders, o)
+	return nil
+}
+
+// match goes through the book and fills, triggers, or expires orders against
+// the given trade, must be called while holding the lock.
+// Orders are matched in the order they were placed and share the volume of
+// the trade, each fill results in its own update.
+// Immediate orders only get to see the first trade after they become active.
+// Updates are applied to the portfolio right away so that each fill sees the
+// balances left by the ones before it.
+func (m *Fake) match(trade *market.Trade) []*market.Update {
+	upds := []*market.Update{}
+	emit := func(upd *market.Update) {
+		m.portfolio.HandleUpdate(upd) // TODO Handle error
+		upds = append(upds, upd)
+	}
+	open := []*order{}
+	available := trade.Size
+	// end takes the order out of the book with the given transition
+	end := func(o *order, transition func() error, at time.Time) {
+		transition() // TODO Handle error
+		emit(o.update(market.Cancel, o.price, o.remaining(), at))
+	}
+	cancel := func(o *order, at time.Time) {
+		end(o, o.state.Cancel, at)
+	}
+	for _, o := range m.orders {
+		if o.pending {
+			// order has not reached the market yet
+			if trade.Time.Before(o.created) {
+				open = append(open, o)
+				continue
+			}
+			o.pending = false
+			if o.postOnly && o.wouldTake(m.lastPrice) {
+				end(o, o.state.Reject, o.created)
+				continue
+			}
+			if !o.stop() {
+				o.activate(m.lastPrice)
+				emit(o.update(market.Activate, o.price, o.size, o.created))
+			}
+		}
+		if o.expired(trade.Time) {
+			end(o, o.state.Expire, o.expires)
+			continue
+		}
+		if o.stop() && !o.triggered {
+			// triggered orders become active from the next trade
+			if o.triggeredBy(trade) {
+				o.triggered = true
+				o.activate(trade.Price)
+				emit(o.update(market.Activate, o.price, o.size, trade.Time))
+			}
+			open = append(open, o)
+			continue
+		}
+		if !o.crossedBy(trade) {
+			if o.immediate() {
+				cancel(o, trade.Time)
+				continue
+			}
+			open = append(open, o)
+			continue
+		}
+		size := m.fillModel.Fill(o.remaining(), available)
+		if o.timeInForce == market.FillOrKill && size < o.remaining()-dust {
+			cancel(o, trade.Time)
+			continue
+		}
+		if size < dust {
+			if o.immediate() {
+				cancel(o, trade.Time)
+				continue
+			}
+			open = append(open, o)
+			continue
+		}
+		// resting orders fill at their price, only takers slip, and limit
+		// takers never fill worse than their limit
+		liquidity := market.Maker
+		price := o.price
+		if o.taker {
+			liquidity = market.Taker
+			price = m.slippage.Slip(o.side, size, trade.Price)
+			switch {
+			case o.market():
+			case o.side == market.Buy:
+				price = math.Min(price, o.price)
+			case o.side == market.Sell:
+				price = math.Max(price, o.price)
+			}
+		}
+		fee, err := m.fill(o, size, price, trade.Time, liquidity)
+		if err != nil {
+			logrus.WithError(err).Debugf("Could not fill order")
+			cancel(o, trade.Time)
+			continue
+		}
+		available -= size
+		upd := o.update(o.side, price, size, trade.Time)
+		upd.Fee = fee
+		upd.Liquidity = liquidity
+		emit(upd)
+		if o.done() {
+			continue
+		}
+		if o.immediate() {
+			cancel(o, trade.Time)
+			continue
+		}
+		// what is left of a limit order rests in the book
+		if !o.market() {
+			o.taker = false
+		}
+		open = append(open, o)
+	}
+	m.orders = open
+	return upds
+}
+
+// fill checks that we can afford part of an order at the given price and
+// returns its fee, the fill's update settles it against our balances.
+// Orders can use what they are holding on top of what is available.
+func (m *Fake) fill(o *order, size, price float64, at time.Time, liquidity market.Liquidity) (float64, error) {
+	ast, cur := currencies(m.productName)
+	held := m.portfolio.Held(o.id)
+	switch o.side {
+	case market.Buy:
+		cost := size * price * (1 + m.fees.Rate(liquidity, at))
+		if cost > m.portfolio.Balance(cur).Available+held {
+			return 0, errors.New("Not enough currency")
+		}
+	case market.Sell:
+		if size > m.portfolio.Balance(ast).Available+held {
+			return 0, errors.New("Not enough assets")
+		}
+	}
+	fee := m.fees.Charge(liquidity, size, price, at)
+	if err := o.state.Fill(size, price, fee); err != nil {
+		return 0, err
+	}
+	return fee, nil
+}
+
+func (m *Fake) notify(upds []*market.Update) {
+	for _, upd := range upds {
+		for _, h := range m.updateHandlers {
+			if h != nil {
+				h.HandleUpdate(upd) // TODO Handle error
+			}
+		}
+	}
+}
+
+// GetBalance -
+func (m *Fake) GetBalance() (asset market.Balance, currency market.Balance, err error) {
+	ast, cur := currencies(m.productName)
+	return m.portfolio.Balance(ast), m.portfolio.Balance(cur), nil
+}
+
+// Fees -
+func (m *Fake) Fees() market.FeeModel {
+	return m.fees
+}
+
+// Account -
+func (m *Fake) Account() market.Account {
+	return m.portfolio
+}
+
+// currencies returns the asset and currency of a product
+func currencies(product string) (string, string) {
+	parts := strings.SplitN(strings.ToUpper(product), "-", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// OrderBook -
+func (m *Fake) OrderBook() market.OrderBook {
+	return m.book
+}
+
+// updateBook approximates the top of the book from trades, the side of a
+// trade is the side of its maker so it was resting at the best bid or ask.
+// The other side is moved to the trade's price if it ends up crossed.
+func (m *Fake) updateBook(trade *market.Trade) {
+	bid, hasBid := m.book.BestBid()
+	ask, hasAsk := m.book.BestAsk()
+	top := market.BookLevel{
+		Price: trade.Price,
+		Size:  trade.Size,
+	}
+	if trade.Side == "buy" {
+		bid, hasBid = top, true
+	} else {
+		ask, hasAsk = top, true
+	}
+	if !hasBid || bid.Price > ask.Price {
+		bid = top
+	}
+	if !hasAsk || ask.Price < bid.Price {
+		ask = top
+	}
+	m.book.Snapshot([]market.BookLevel{bid}, []market.BookLevel{ask})
 }
 
 func (m *Fake) Run() {
-	end := time.Now()
-	start := end.Add(-m.back)
-	// TODO make this async and send smaller batches
-	trades, err := m.persistence.GetTrades(m.marketName, m.productName, start, end)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trades, errs := m.persistence.StreamTrades(ctx, m.marketName, m.productName, m.start, m.end)
+	// replay recorded book updates alongside the trades, starting from the
+	// last snapshot before start as changes only make sense on top of one.
+	// Until there is a snapshot the book is approximated from the trades.
+	from := m.start
+	snapshot, err := m.persistence.GetBookSnapshot(m.marketName, m.productName, m.start)
 	if err != nil {
-		fmt.Println("Could not get trades", err)
-		return
+		fmt.Println("Could not get book snapshot", err)
 	}
-	if len(trades) == 0 {
-		fmt.Println("No trades for the given duration, you might want to backfill first.")
-		fmt.Println("eg. go-trade backfill --days 5")
-		return
+	if snapshot != nil {
+		from = snapshot.Time
 	}
-	for _, trade := range trades {
+	books, bookErrs := m.persistence.StreamBookUpdates(ctx, m.marketName, m.productName, from, m.end)
+	nextBook, booksOpen := <-books
+	replayed := false
+	count := 0
+	for trade := range trades {
+		count++
+		for booksOpen && !nextBook.Time.After(trade.Time) {
+			if replayed || nextBook.Type == market.BookSnapshot {
+				nextBook.Apply(m.book)
+				replayed = true
+			}
+			nextBook, booksOpen = <-books
+		}
+		// match our orders before anyone gets to see the trade so that
+		// orders placed because of this trade can only be filled by later ones
+		m.Lock()
+		m.slippage.HandleTrade(trade) // TODO Handle error
+		upds := m.match(trade)
+		m.now = trade.Time
+		m.lastPrice = trade.Price
+		if !replayed {
+			m.updateBook(trade)
+		}
+		m.Unlock()
+		m.notify(upds)
 		for _, h := range m.handlers {
 			if h != nil {
 				h.HandleTrade(trade) // TODO Handle error
 			}
 		}
 	}
+	cancel()
+	for range books {
+	}
+	if err := <-bookErrs; err != nil && err != context.Canceled {
+		fmt.Println("Could not get book updates", err)
+	}
+	if err := <-errs; err != nil && err != context.Canceled {
+		fmt.Println("Could not get trades", err)
+		return
+	}
+	if count == 0 {
+		fmt.Println("No trades for the given duration, you might want to backfill first.")
+		fmt.Println("eg. go-trade backfill --days 5")
+	}
 }
 
-func (m *Fake) Backfill(end time.Time) error {
+func (m *Fake) Backfill(start, end time.Time) error {
 	return errors.New("Not implemented")
 }
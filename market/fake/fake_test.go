@@ -0,0 +1,554 @@
+package fake
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+	persistence "github.com/geoah/go-trade/persistence"
+)
+
+// placer places an order when it sees the first trade
+type placer struct {
+	market market.Market
+	req    market.OrderRequest
+	placed bool
+	err    error
+}
+
+func (p *placer) HandleTrade(trade *market.Trade) error {
+	if !p.placed {
+		p.placed = true
+		_, p.err = p.market.PlaceOrder(p.req)
+	}
+	return nil
+}
+
+// fills keeps the fill updates
+type fills []*market.Update
+
+func (f *fills) HandleUpdate(upd *market.Update) error {
+	if upd.Action == market.Buy || upd.Action == market.Sell {
+		*f = append(*f, upd)
+	}
+	return nil
+}
+
+func TestFakeFills(t *testing.T) {
+	start := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	type fill struct {
+		after     time.Duration
+		price     float64
+		size      float64
+		liquidity market.Liquidity
+	}
+	type trade struct {
+		after time.Duration
+		price float64
+		size  float64
+	}
+	limitBuy := market.OrderRequest{
+		Side:  market.Buy,
+		Type:  market.LimitOrder,
+		Size:  1,
+		Price: 102,
+	}
+	tests := []struct {
+		name     string
+		fill     string
+		slippage float64
+		latency  time.Duration
+		req      market.OrderRequest
+		trades   []trade
+		fills    []fill
+	}{
+		{
+			name:     "makers fill at their price",
+			fill:     FillModelFull,
+			slippage: 100,
+			req:      market.NewPostOnlyRequest(market.Buy, 1, 100),
+			trades:   []trade{{10 * time.Second, 100, 1}, {20 * time.Second, 99, 1}},
+			fills:    []fill{{20 * time.Second, 100, 1, market.Maker}},
+		},
+		{
+			name:     "takers slip",
+			fill:     FillModelFull,
+			slippage: 10,
+			req:      limitBuy,
+			trades:   []trade{{10 * time.Second, 101, 1}},
+			fills:    []fill{{10 * time.Second, 101.101, 1, market.Taker}},
+		},
+		{
+			name:     "takers don't slip beyond their limit",
+			fill:     FillModelFull,
+			slippage: 100,
+			req:      limitBuy,
+			trades:   []trade{{10 * time.Second, 101, 1}},
+			fills:    []fill{{10 * time.Second, 102, 1, market.Taker}},
+		},
+		{
+			name:    "latency",
+			fill:    FillModelFull,
+			latency: 30 * time.Second,
+			req:     market.NewPostOnlyRequest(market.Buy, 1, 100),
+			trades:  []trade{{10 * time.Second, 99, 1}, {20 * time.Second, 101, 1}, {40 * time.Second, 99.5, 1}},
+			fills:   []fill{{40 * time.Second, 100, 1, market.Maker}},
+		},
+		{
+			name:   "volume",
+			fill:   FillModelVolume,
+			req:    market.NewPostOnlyRequest(market.Buy, 2, 100),
+			trades: []trade{{10 * time.Second, 99, 0.5}, {20 * time.Second, 99, 2}},
+			fills: []fill{
+				{10 * time.Second, 100, 0.5, market.Maker},
+				{20 * time.Second, 100, 1.5, market.Maker},
+			},
+		},
+	}
+	for _, test := range tests {
+		pe, _ := persistence.NewMemory()
+		trades := []*market.Trade{{
+			ID:      "gdax.BTC-USD.0",
+			Market:  "gdax",
+			Product: "BTC-USD",
+			Time:    start,
+			Price:   101,
+			Size:    1,
+		}}
+		for i, tr := range test.trades {
+			trades = append(trades, &market.Trade{
+				ID:      fmt.Sprintf("gdax.BTC-USD.%d", i+1),
+				Market:  "gdax",
+				Product: "BTC-USD",
+				TradeID: i + 1,
+				Time:    start.Add(tr.after),
+				Price:   tr.price,
+				Size:    tr.size,
+			})
+		}
+		if err := pe.PutTrade(trades...); err != nil {
+			t.Fatal(err)
+		}
+		fm, _ := NewFillModel(test.fill)
+		sm, _ := NewSlippageModel(SlippageFixed, test.slippage)
+		m, _ := New(pe, "gdax", "BTC-USD", start, start.Add(time.Hour), 0, 10000, fm, market.NewFlatFees(0, 0.003), sm, NewFixedLatency(test.latency))
+		p := &placer{
+			market: m,
+			req:    test.req,
+		}
+		got := &fills{}
+		m.RegisterForTrades(p)
+		m.RegisterForUpdates(got)
+		m.Run()
+		if p.err != nil {
+			t.Fatalf("%s: %v", test.name, p.err)
+		}
+		if len(*got) != len(test.fills) {
+			t.Fatalf("%s: expected %d fills, got %d", test.name, len(test.fills), len(*got))
+		}
+		for i, f := range test.fills {
+			upd := (*got)[i]
+			if !upd.Time.Equal(start.Add(f.after)) ||
+				math.Abs(upd.Price-f.price) > 1e-9 ||
+				math.Abs(upd.Size-f.size) > 1e-9 ||
+				upd.Liquidity != f.liquidity {
+				t.Errorf("%s: expected fill %+v, got %s %v %v %s", test.name, f, upd.Time.Sub(start), upd.Price, upd.Size, upd.Liquidity)
+			}
+			fee := 0.0
+			if f.liquidity == market.Taker {
+				fee = f.price * f.size * 0.003
+			}
+			if math.Abs(upd.Fee-fee) > 1e-9 {
+				t.Errorf("%s: expected a fee of %v, got %v", test.name, fee, upd.Fee)
+			}
+		}
+	}
+}
+
+func TestFakeBookReplay(t *testing.T) {
+	start := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	level := func(price, size float64) []market.BookLevel {
+		return []market.BookLevel{{Price: price, Size: size}}
+	}
+	snapshot := func(after time.Duration, seq int64, bid, ask float64) *market.BookUpdate {
+		return &market.BookUpdate{
+			Market:   "gdax",
+			Product:  "BTC-USD",
+			Type:     market.BookSnapshot,
+			Time:     start.Add(after),
+			Sequence: seq,
+			Bids:     level(bid, 1),
+			Asks:     level(ask, 1),
+		}
+	}
+	change := func(after time.Duration, seq int64, bids, asks []market.BookLevel) *market.BookUpdate {
+		return &market.BookUpdate{
+			Market:   "gdax",
+			Product:  "BTC-USD",
+			Type:     market.BookChange,
+			Time:     start.Add(after),
+			Sequence: seq,
+			Bids:     bids,
+			Asks:     asks,
+		}
+	}
+	tests := []struct {
+		name    string
+		updates []*market.BookUpdate
+		bid     float64
+		ask     float64
+	}{
+		{
+			name: "seeded from the snapshot before start",
+			updates: []*market.BookUpdate{
+				snapshot(-time.Minute, 1, 99, 101),
+				change(-30*time.Second, 2, level(99.5, 1), nil),
+				change(5*time.Second, 3, nil, level(100.5, 1)),
+			},
+			bid: 99.5,
+			ask: 100.5,
+		},
+		{
+			name: "changes without a snapshot are skipped",
+			updates: []*market.BookUpdate{
+				change(-30*time.Second, 1, level(99.5, 1), nil),
+				change(5*time.Second, 2, level(99.8, 1), nil),
+			},
+			bid: 100,
+			ask: 100,
+		},
+		{
+			name: "seeded from a snapshot during the run",
+			updates: []*market.BookUpdate{
+				change(5*time.Second, 1, level(98, 1), nil),
+				snapshot(15*time.Second, 2, 99, 101),
+				change(25*time.Second, 3, level(99.5, 1), nil),
+			},
+			bid: 99.5,
+			ask: 101,
+		},
+		{
+			name: "updates with the same time follow their sequence",
+			updates: []*market.BookUpdate{
+				snapshot(-time.Minute, 1, 99, 101),
+				change(-30*time.Second, 3, level(99.5, 0), nil),
+				change(-30*time.Second, 2, level(99.5, 1), nil),
+			},
+			bid: 99,
+			ask: 101,
+		},
+	}
+	for _, test := range tests {
+		pe, _ := persistence.NewMemory()
+		trades := []*market.Trade{}
+		for i := 1; i <= 3; i++ {
+			trades = append(trades, &market.Trade{
+				ID:      fmt.Sprintf("gdax.BTC-USD.%d", i),
+				Market:  "gdax",
+				Product: "BTC-USD",
+				TradeID: i,
+				Time:    start.Add(time.Duration(i) * 10 * time.Second),
+				Price:   100,
+				Size:    1,
+				Side:    "buy",
+			})
+		}
+		if err := pe.PutTrade(trades...); err != nil {
+			t.Fatal(err)
+		}
+		if err := pe.PutBookUpdate(test.updates...); err != nil {
+			t.Fatal(err)
+		}
+		fm, _ := NewFillModel(FillModelFull)
+		sm, _ := NewSlippageModel(SlippageNone, 0)
+		m, _ := New(pe, "gdax", "BTC-USD", start, start.Add(time.Hour), 0, 10000, fm, market.NewFlatFees(0, 0.003), sm, NewFixedLatency(0))
+		m.Run()
+		bid, _ := m.OrderBook().BestBid()
+		ask, _ := m.OrderBook().BestAsk()
+		if bid.Price != test.bid || ask.Price != test.ask {
+			t.Errorf("%s: expected a bid of %v and an ask of %v, got %v and %v", test.name, test.bid, test.ask, bid.Price, ask.Price)
+		}
+	}
+}
+
+// script places orders on the first trade, and runs a step on the trade at
+// the given time
+type script struct {
+	market market.Market
+	reqs   []market.OrderRequest
+	at     time.Time
+	step   func(m market.Market, ids []string) error
+	ids    []string
+	err    error
+}
+
+func (s *script) HandleTrade(trade *market.Trade) error {
+	if s.ids == nil {
+		s.ids = []string{}
+		for _, req := range s.reqs {
+			ord, err := s.market.PlaceOrder(req)
+			if err != nil {
+				s.err = err
+				continue
+			}
+			s.ids = append(s.ids, ord.ID)
+		}
+	}
+	if s.step != nil && trade.Time.Equal(s.at) {
+		if err := s.step(s.market, s.ids); err != nil {
+			s.err = err
+		}
+	}
+	return nil
+}
+
+// events keeps the updates as strings relative to start
+type events struct {
+	start time.Time
+	list  []string
+}
+
+func (e *events) HandleUpdate(upd *market.Update) error {
+	e.list = append(e.list, fmt.Sprintf("%s %s %s %g", upd.Time.Sub(e.start), upd.Action, upd.Status, upd.Size))
+	return nil
+}
+
+func TestFakeOrders(t *testing.T) {
+	start := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	type trade struct {
+		after time.Duration
+		price float64
+		size  float64
+	}
+	limit := func(side market.Action, size, price float64, tif market.TimeInForce) market.OrderRequest {
+		return market.OrderRequest{
+			Side:        side,
+			Type:        market.LimitOrder,
+			Size:        size,
+			Price:       price,
+			TimeInForce: tif,
+		}
+	}
+	// held checks the currency held by our orders
+	held := func(amount float64) func(m market.Market, ids []string) error {
+		return func(m market.Market, ids []string) error {
+			if _, cur, _ := m.GetBalance(); math.Abs(cur.Hold-amount) > 1e-9 {
+				return fmt.Errorf("expected %v to be held, got %v", amount, cur.Hold)
+			}
+			return nil
+		}
+	}
+	tests := []struct {
+		name    string
+		fill    string
+		latency time.Duration
+		reqs    []market.OrderRequest
+		trades  []trade
+		at      time.Duration
+		step    func(m market.Market, ids []string) error
+		err     error
+		events  []string
+	}{
+		{
+			name:   "post only orders that would take are rejected",
+			reqs:   []market.OrderRequest{market.NewPostOnlyRequest(market.Buy, 1, 102)},
+			trades: []trade{{10 * time.Second, 101, 1}},
+			err:    ErrorOrderRejected,
+			events: []string{},
+		},
+		{
+			name:    "post only orders that would take on arrival are rejected",
+			latency: 30 * time.Second,
+			reqs:    []market.OrderRequest{market.NewPostOnlyRequest(market.Buy, 1, 100)},
+			trades:  []trade{{10 * time.Second, 99, 1}, {40 * time.Second, 99, 1}},
+			events: []string{
+				"0s OPEN pending 1",
+				"30s CANCEL rejected 1",
+			},
+		},
+		{
+			name:   "good till time orders expire",
+			reqs:   []market.OrderRequest{market.NewPostOnlyRequest(market.Buy, 1, 100)},
+			trades: []trade{{30 * time.Second, 100.5, 1}, {70 * time.Second, 100.5, 1}},
+			at:     30 * time.Second,
+			step:   held(100),
+			events: []string{
+				"0s OPEN open 1",
+				"1m0s CANCEL expired 1",
+			},
+		},
+		{
+			name:   "immediate or cancel orders cancel what they can't fill",
+			fill:   FillModelVolume,
+			reqs:   []market.OrderRequest{limit(market.Buy, 2, 102, market.ImmediateOrCancel)},
+			trades: []trade{{10 * time.Second, 101, 0.5}},
+			events: []string{
+				"0s OPEN open 2",
+				"10s BUY partially_filled 0.5",
+				"10s CANCEL cancelled 1.5",
+			},
+		},
+		{
+			name:   "immediate or cancel orders that are not crossed are cancelled",
+			reqs:   []market.OrderRequest{limit(market.Buy, 1, 100, market.ImmediateOrCancel)},
+			trades: []trade{{10 * time.Second, 101, 1}, {20 * time.Second, 99, 1}},
+			events: []string{
+				"0s OPEN open 1",
+				"10s CANCEL cancelled 1",
+			},
+		},
+		{
+			name:   "fill or kill orders are killed if they can't fill",
+			fill:   FillModelVolume,
+			reqs:   []market.OrderRequest{limit(market.Buy, 2, 102, market.FillOrKill)},
+			trades: []trade{{10 * time.Second, 101, 0.5}},
+			events: []string{
+				"0s OPEN open 2",
+				"10s CANCEL cancelled 2",
+			},
+		},
+		{
+			name:   "fill or kill orders fill completely",
+			fill:   FillModelVolume,
+			reqs:   []market.OrderRequest{limit(market.Buy, 1, 102, market.FillOrKill)},
+			trades: []trade{{10 * time.Second, 101, 2}},
+			events: []string{
+				"0s OPEN open 1",
+				"10s BUY filled 1",
+			},
+		},
+		{
+			name: "stop orders trigger and fill on the next trade",
+			reqs: []market.OrderRequest{{
+				Side:      market.Buy,
+				Type:      market.StopOrder,
+				Size:      1,
+				StopPrice: 103,
+			}},
+			trades: []trade{{10 * time.Second, 102, 1}, {20 * time.Second, 103, 1}, {30 * time.Second, 104, 1}},
+			at:     10 * time.Second,
+			step:   held(103),
+			events: []string{
+				"0s OPEN pending 1",
+				"20s ACTIVATE open 1",
+				"30s BUY filled 1",
+			},
+		},
+		{
+			name: "stop limit orders fill within their limit",
+			reqs: []market.OrderRequest{{
+				Side:      market.Sell,
+				Type:      market.StopLimitOrder,
+				Size:      1,
+				Price:     98.5,
+				StopPrice: 99,
+			}},
+			trades: []trade{{10 * time.Second, 100, 1}, {20 * time.Second, 99, 1}, {30 * time.Second, 98, 1}, {40 * time.Second, 99.5, 1}},
+			events: []string{
+				"0s OPEN pending 1",
+				"20s ACTIVATE open 1",
+				"40s SELL filled 1",
+			},
+		},
+		{
+			name:   "cancelling releases the hold",
+			reqs:   []market.OrderRequest{market.NewPostOnlyRequest(market.Buy, 1, 100)},
+			trades: []trade{{10 * time.Second, 100.5, 1}, {20 * time.Second, 99, 1}},
+			at:     10 * time.Second,
+			step: func(m market.Market, ids []string) error {
+				if err := held(100)(m, ids); err != nil {
+					return err
+				}
+				if err := m.CancelOrder(ids[0]); err != nil {
+					return err
+				}
+				if err := m.CancelOrder(ids[0]); err != ErrorOrderNotFound {
+					return fmt.Errorf("expected cancelling twice to fail, got %v", err)
+				}
+				return held(0)(m, ids)
+			},
+			events: []string{
+				"0s OPEN open 1",
+				"10s CANCEL cancelled 1",
+			},
+		},
+		{
+			name:   "amending replaces the order",
+			reqs:   []market.OrderRequest{market.NewPostOnlyRequest(market.Buy, 1, 100)},
+			trades: []trade{{10 * time.Second, 100.5, 1}, {20 * time.Second, 98.5, 2}},
+			at:     10 * time.Second,
+			step: func(m market.Market, ids []string) error {
+				ord, err := m.AmendOrder(ids[0], 2, 99)
+				if err != nil {
+					return err
+				}
+				if ord.ID == ids[0] || ord.Size != 2 || ord.Price != 99 || ord.TimeInForce != market.GoodTillTime {
+					return fmt.Errorf("expected a new order for 2 at 99, got %+v", ord)
+				}
+				if _, err := m.AmendOrder(ids[0], 1, 99); err != ErrorOrderNotFound {
+					return fmt.Errorf("expected amending a cancelled order to fail, got %v", err)
+				}
+				return held(198)(m, ids)
+			},
+			events: []string{
+				"0s OPEN open 1",
+				"10s CANCEL cancelled 1",
+				"10s OPEN open 2",
+				"20s BUY filled 2",
+			},
+		},
+	}
+	for _, test := range tests {
+		pe, _ := persistence.NewMemory()
+		trades := []*market.Trade{{
+			ID:      "gdax.BTC-USD.0",
+			Market:  "gdax",
+			Product: "BTC-USD",
+			Time:    start,
+			Price:   101,
+			Size:    1,
+		}}
+		for i, tr := range test.trades {
+			trades = append(trades, &market.Trade{
+				ID:      fmt.Sprintf("gdax.BTC-USD.%d", i+1),
+				Market:  "gdax",
+				Product: "BTC-USD",
+				TradeID: i + 1,
+				Time:    start.Add(tr.after),
+				Price:   tr.price,
+				Size:    tr.size,
+			})
+		}
+		if err := pe.PutTrade(trades...); err != nil {
+			t.Fatal(err)
+		}
+		fill := test.fill
+		if fill == "" {
+			fill = FillModelFull
+		}
+		fm, _ := NewFillModel(fill)
+		sm, _ := NewSlippageModel(SlippageNone, 0)
+		m, _ := New(pe, "gdax", "BTC-USD", start, start.Add(time.Hour), 10, 10000, fm, market.NewFlatFees(0, 0.003), sm, NewFixedLatency(test.latency))
+		s := &script{
+			market: m,
+			reqs:   test.reqs,
+			at:     start.Add(test.at),
+			step:   test.step,
+		}
+		got := &events{start: start, list: []string{}}
+		m.RegisterForTrades(s)
+		m.RegisterForUpdates(got)
+		m.Run()
+		if s.err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, s.err)
+		}
+		if fmt.Sprint(got.list) != fmt.Sprint(test.events) {
+			t.Errorf("%s: expected updates %q, got %q", test.name, test.events, got.list)
+		}
+		if ast, cur, _ := m.GetBalance(); ast.Hold != 0 || cur.Hold != 0 {
+			t.Errorf("%s: expected nothing to be held at the end, got %v and %v", test.name, ast.Hold, cur.Hold)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package fake
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// FillModelFull fills the whole order as soon as a trade crosses it
+	FillModelFull = "full"
+	// FillModelVolume only fills as much as the crossing trades traded
+	FillModelVolume = "volume"
+)
+
+// FillModel decides how much of an order gets filled by a crossing trade
+type FillModel interface {
+	// Fill returns the size that gets filled given the remaining size of the
+	// order and the volume of the crossing trade that has not been used to
+	// fill other orders yet
+	Fill(remaining, available float64) float64
+}
+
+// NewFillModel returns the fill model with the given name
+func NewFillModel(name string) (FillModel, error) {
+	switch name {
+	case FillModelFull:
+		return &fullFill{}, nil
+	case FillModelVolume:
+		return &volumeFill{}, nil
+	}
+	return nil, fmt.Errorf("Unknown fill model %s", name)
+}
+
+// fullFill assumes there was always enough liquidity behind a trade
+type fullFill struct{}
+
+func (f *fullFill) Fill(remaining, available float64) float64 {
+	return remaining
+}
+
+// volumeFill assumes we could only have taken the trade's volume
+type volumeFill struct{}
+
+func (f *volumeFill) Fill(remaining, available float64) float64 {
+	return math.Max(0, math.Min(remaining, available))
+}
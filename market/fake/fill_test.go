@@ -0,0 +1,33 @@
+package fake
+
+import (
+	"testing"
+)
+
+func TestFillModels(t *testing.T) {
+	tests := []struct {
+		model     string
+		remaining float64
+		available float64
+		fill      float64
+	}{
+		{FillModelFull, 2, 1, 2},
+		{FillModelFull, 2, 0, 2},
+		{FillModelVolume, 2, 1, 1},
+		{FillModelVolume, 1, 2, 1},
+		{FillModelVolume, 2, 0, 0},
+		{FillModelVolume, 2, -1, 0},
+	}
+	for _, test := range tests {
+		fm, err := NewFillModel(test.model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fill := fm.Fill(test.remaining, test.available); fill != test.fill {
+			t.Errorf("%s: expected %v of %v with %v available, got %v", test.model, test.fill, test.remaining, test.available, fill)
+		}
+	}
+	if _, err := NewFillModel("nope"); err == nil {
+		t.Errorf("expected an unknown fill model to fail")
+	}
+}
@@ -0,0 +1,48 @@
+package fake
+
+import (
+	"math/rand"
+	"time"
+)
+
+// LatencyModel decides how long it takes for an order to reach the market
+type LatencyModel interface {
+	// Delay returns the replayed time between placing an order and the order
+	// becoming visible to the matcher
+	Delay() time.Duration
+}
+
+// NewFixedLatency delays all orders by the same duration
+func NewFixedLatency(delay time.Duration) LatencyModel {
+	return &fixedLatency{
+		delay: delay,
+	}
+}
+
+type fixedLatency struct {
+	delay time.Duration
+}
+
+func (l *fixedLatency) Delay() time.Duration {
+	return l.delay
+}
+
+// NewRandomLatency delays orders by delay plus a random duration up to jitter
+func NewRandomLatency(delay, jitter time.Duration) LatencyModel {
+	return &randomLatency{
+		delay:  delay,
+		jitter: jitter,
+	}
+}
+
+type randomLatency struct {
+	delay  time.Duration
+	jitter time.Duration
+}
+
+func (l *randomLatency) Delay() time.Duration {
+	if l.jitter <= 0 {
+		return l.delay
+	}
+	return l.delay + time.Duration(rand.Int63n(int64(l.jitter)))
+}
@@ -0,0 +1,27 @@
+package fake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyModels(t *testing.T) {
+	tests := []struct {
+		name  string
+		model LatencyModel
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"fixed", NewFixedLatency(time.Second), time.Second, time.Second},
+		{"random without jitter", NewRandomLatency(time.Second, 0), time.Second, time.Second},
+		{"random", NewRandomLatency(time.Second, time.Second), time.Second, 2*time.Second - 1},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			if d := test.model.Delay(); d < test.min || d > test.max {
+				t.Errorf("%s: expected a delay between %s and %s, got %s", test.name, test.min, test.max, d)
+				break
+			}
+		}
+	}
+}
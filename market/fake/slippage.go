@@ -0,0 +1,123 @@
+package fake
+
+import (
+	"fmt"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	// SlippageNone fills orders at their price
+	SlippageNone = "none"
+	// SlippageFixed moves the fill price by a fixed number of basis points
+	SlippageFixed = "fixed"
+	// SlippageVolume moves the fill price proportionally to the size of the
+	// fill compared to the recently traded volume
+	SlippageVolume = "volume"
+	// SlippageSpread moves the fill price by half the estimated spread
+	SlippageSpread = "spread"
+)
+
+// SlippageModel decides how much worse than the trade's price orders that take
+// liquidity get filled at
+type SlippageModel interface {
+	// HandleTrade allows the model to follow the market
+	HandleTrade(trade *market.Trade) error
+	// Slip returns the price a fill of the given side and size gets filled at
+	Slip(side market.Action, size, price float64) float64
+}
+
+// NewSlippageModel returns the slippage model with the given name, bps is
+// the fixed slippage in basis points, or the slippage for a fill as large as
+// the volume traded in the last minute for the volume model
+func NewSlippageModel(name string, bps float64) (SlippageModel, error) {
+	switch name {
+	case SlippageNone:
+		return &fixedSlippage{}, nil
+	case SlippageFixed:
+		return &fixedSlippage{bps: bps}, nil
+	case SlippageVolume:
+		return &volumeSlippage{bps: bps, window: time.Minute}, nil
+	case SlippageSpread:
+		return &spreadSlippage{}, nil
+	}
+	return nil, fmt.Errorf("Unknown slippage model %s", name)
+}
+
+// slip moves the price against us
+func slip(side market.Action, price, diff float64) float64 {
+	if side == market.Sell {
+		return price - diff
+	}
+	return price + diff
+}
+
+type fixedSlippage struct {
+	bps float64
+}
+
+func (s *fixedSlippage) HandleTrade(trade *market.Trade) error {
+	return nil
+}
+
+func (s *fixedSlippage) Slip(side market.Action, size, price float64) float64 {
+	return slip(side, price, price*s.bps/10000)
+}
+
+type volumeSlippage struct {
+	bps    float64
+	window time.Duration
+	trades []*market.Trade
+	volume float64
+}
+
+func (s *volumeSlippage) HandleTrade(trade *market.Trade) error {
+	s.trades = append(s.trades, trade)
+	s.volume += trade.Size
+	// forget trades that are out of our window
+	from := trade.Time.Add(-s.window)
+	i := 0
+	for i < len(s.trades) && s.trades[i].Time.Before(from) {
+		s.volume -= s.trades[i].Size
+		i++
+	}
+	s.trades = s.trades[i:]
+	return nil
+}
+
+func (s *volumeSlippage) Slip(side market.Action, size, price float64) float64 {
+	if s.volume <= 0 {
+		return price
+	}
+	return slip(side, price, price*s.bps/10000*size/s.volume)
+}
+
+// spreadSlippage estimates the spread from the last trades on each side of
+// the book. Models only see trades, so this does not use the fake market's
+// book, even when it is replayed from recorded book updates.
+type spreadSlippage struct {
+	lastBuy  float64
+	lastSell float64
+}
+
+func (s *spreadSlippage) HandleTrade(trade *market.Trade) error {
+	switch trade.Side {
+	case "buy":
+		s.lastBuy = trade.Price
+	case "sell":
+		s.lastSell = trade.Price
+	}
+	return nil
+}
+
+func (s *spreadSlippage) Slip(side market.Action, size, price float64) float64 {
+	if s.lastBuy == 0 || s.lastSell == 0 {
+		return price
+	}
+	spread := s.lastSell - s.lastBuy
+	if spread < 0 {
+		spread = -spread
+	}
+	return slip(side, price, spread/2)
+}
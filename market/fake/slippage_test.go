@@ -0,0 +1,121 @@
+package fake
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+func TestSlippageModels(t *testing.T) {
+	start := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	trade := func(s int, side string, price, size float64) *market.Trade {
+		return &market.Trade{
+			Time:  start.Add(time.Duration(s) * time.Second),
+			Side:  side,
+			Price: price,
+			Size:  size,
+		}
+	}
+	tests := []struct {
+		name   string
+		model  string
+		bps    float64
+		trades []*market.Trade
+		side   market.Action
+		size   float64
+		price  float64
+	}{
+		{
+			name:  "none",
+			model: SlippageNone,
+			side:  market.Buy,
+			size:  1,
+			price: 100,
+		},
+		{
+			name:  "fixed buy",
+			model: SlippageFixed,
+			bps:   10,
+			side:  market.Buy,
+			size:  1,
+			price: 100.1,
+		},
+		{
+			name:  "fixed sell",
+			model: SlippageFixed,
+			bps:   10,
+			side:  market.Sell,
+			size:  1,
+			price: 99.9,
+		},
+		{
+			name:  "volume without trades",
+			model: SlippageVolume,
+			bps:   100,
+			side:  market.Buy,
+			size:  1,
+			price: 100,
+		},
+		{
+			name:  "volume",
+			model: SlippageVolume,
+			bps:   100,
+			trades: []*market.Trade{
+				trade(0, "buy", 100, 3),
+				trade(30, "sell", 100, 2),
+			},
+			side:  market.Buy,
+			size:  1,
+			price: 100.2,
+		},
+		{
+			name:  "volume forgets old trades",
+			model: SlippageVolume,
+			bps:   100,
+			trades: []*market.Trade{
+				trade(0, "buy", 100, 3),
+				trade(90, "sell", 100, 2),
+			},
+			side:  market.Sell,
+			size:  1,
+			price: 99.5,
+		},
+		{
+			name:  "spread",
+			model: SlippageSpread,
+			trades: []*market.Trade{
+				trade(0, "buy", 99, 1),
+				trade(1, "sell", 101, 1),
+			},
+			side:  market.Buy,
+			size:  1,
+			price: 101,
+		},
+		{
+			name:  "spread with one side",
+			model: SlippageSpread,
+			trades: []*market.Trade{
+				trade(0, "buy", 99, 1),
+			},
+			side:  market.Sell,
+			size:  1,
+			price: 100,
+		},
+	}
+	for _, test := range tests {
+		sm, err := NewSlippageModel(test.model, test.bps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tr := range test.trades {
+			if err := sm.HandleTrade(tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if price := sm.Slip(test.side, test.size, 100); math.Abs(price-test.price) > 1e-9 {
+			t.Errorf("%s: expected a price of %v, got %v", test.name, test.price, price)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package market
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// FeeVolumeWindow is how far back fills count towards the fee tier
+	FeeVolumeWindow = 30 * 24 * time.Hour
+)
+
+// Liquidity -
+type Liquidity string
+
+const (
+	// Maker fills added liquidity to the book
+	Maker Liquidity = "M"
+	// Taker fills removed liquidity from the book
+	Taker Liquidity = "T"
+)
+
+// FeeModel calculates the fees of fills
+type FeeModel interface {
+	// Charge returns the fee in quote currency for a fill, and accounts for
+	// its volume for any future fills
+	Charge(liquidity Liquidity, size, price float64, at time.Time) float64
+	// Rate returns the fee rate that would apply at the given time
+	Rate(liquidity Liquidity, at time.Time) float64
+}
+
+// FeeTier is a set of fee rates, applying once the trailing volume in quote
+// currency reaches Volume
+type FeeTier struct {
+	Volume float64
+	Maker  float64
+	Taker  float64
+}
+
+type feeFill struct {
+	time   time.Time
+	volume float64
+}
+
+// FeeSchedule is a tiered FeeModel based on trailing volume
+type FeeSchedule struct {
+	sync.Mutex
+	tiers []FeeTier
+	// volume we had before our first fill
+	volume float64
+	fills  []feeFill
+}
+
+// NewFeeSchedule creates a fee schedule, with volume being the trailing volume
+// before we started trading
+func NewFeeSchedule(volume float64, tiers ...FeeTier) *FeeSchedule {
+	ts := append([]FeeTier{}, tiers...)
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Volume < ts[j].Volume
+	})
+	return &FeeSchedule{
+		tiers:  ts,
+		volume: volume,
+		fills:  []feeFill{},
+	}
+}
+
+// NewFlatFees creates a fee schedule with a single tier
+func NewFlatFees(maker, taker float64) *FeeSchedule {
+	return NewFeeSchedule(0, FeeTier{
+		Maker: maker,
+		Taker: taker,
+	})
+}
+
+// Charge -
+func (s *FeeSchedule) Charge(liquidity Liquidity, size, price float64, at time.Time) float64 {
+	s.Lock()
+	defer s.Unlock()
+	fee := size * price * s.rate(liquidity, at)
+	s.fills = append(s.fills, feeFill{
+		time:   at,
+		volume: size * price,
+	})
+	return fee
+}
+
+// Rate -
+func (s *FeeSchedule) Rate(liquidity Liquidity, at time.Time) float64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.rate(liquidity, at)
+}
+
+// Volume returns the trailing volume at the given time
+func (s *FeeSchedule) Volume(at time.Time) float64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.trailingVolume(at)
+}
+
+func (s *FeeSchedule) rate(liquidity Liquidity, at time.Time) float64 {
+	vol := s.trailingVolume(at)
+	tier := FeeTier{}
+	for _, t := range s.tiers {
+		if vol < t.Volume {
+			break
+		}
+		tier = t
+	}
+	if liquidity == Maker {
+		return tier.Maker
+	}
+	return tier.Taker
+}
+
+func (s *FeeSchedule) trailingVolume(at time.Time) float64 {
+	// drop fills that have fallen out of the window
+	from := at.Add(-FeeVolumeWindow)
+	i := 0
+	for i < len(s.fills) && s.fills[i].time.Before(from) {
+		i++
+	}
+	s.fills = s.fills[i:]
+	vol := s.volume
+	for _, f := range s.fills {
+		vol += f.volume
+	}
+	return vol
+}
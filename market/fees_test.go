@@ -0,0 +1,78 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeeScheduleTiers(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	tiers := []FeeTier{
+		{Volume: 1000, Maker: 0, Taker: 0.002},
+		{Volume: 0, Maker: 0, Taker: 0.003},
+		{Volume: 10000, Maker: 0, Taker: 0.001},
+	}
+	tests := []struct {
+		name      string
+		volume    float64
+		fills     []float64
+		at        time.Time
+		liquidity Liquidity
+		rate      float64
+	}{
+		{
+			name:      "first tier",
+			at:        start,
+			liquidity: Taker,
+			rate:      0.003,
+		},
+		{
+			name:      "maker",
+			volume:    5000,
+			at:        start,
+			liquidity: Maker,
+			rate:      0,
+		},
+		{
+			name:      "starting volume",
+			volume:    1000,
+			at:        start,
+			liquidity: Taker,
+			rate:      0.002,
+		},
+		{
+			name:      "volume from fills",
+			volume:    500,
+			fills:     []float64{5000, 5000},
+			at:        start,
+			liquidity: Taker,
+			rate:      0.001,
+		},
+		{
+			name:      "fills out of the window",
+			fills:     []float64{5000, 5000},
+			at:        start.Add(FeeVolumeWindow + time.Hour),
+			liquidity: Taker,
+			rate:      0.003,
+		},
+	}
+	for _, test := range tests {
+		fs := NewFeeSchedule(test.volume, tiers...)
+		for _, volume := range test.fills {
+			fs.Charge(Taker, 1, volume, start)
+		}
+		if rate := fs.Rate(test.liquidity, test.at); rate != test.rate {
+			t.Errorf("%s: expected a rate of %v, got %v", test.name, test.rate, rate)
+		}
+	}
+}
+
+func TestFeeScheduleCharge(t *testing.T) {
+	fs := NewFlatFees(0.001, 0.003)
+	if fee := fs.Charge(Taker, 2, 100, time.Time{}); fee != 0.6 {
+		t.Errorf("expected a taker fee of 0.6, got %v", fee)
+	}
+	if fee := fs.Charge(Maker, 2, 100, time.Time{}); fee != 0.2 {
+		t.Errorf("expected a maker fee of 0.2, got %v", fee)
+	}
+}
@@ -0,0 +1,311 @@
+package gdax
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	// gdax allows 3 requests per second on public endpoints and 5 on private
+	// ones, with bursts of up to twice that
+	publicRate   = 3
+	publicBurst  = 6
+	privateRate  = 5
+	privateBurst = 10
+
+	// DefaultMaxRetries is the number of times a request is retried
+	DefaultMaxRetries = 5
+
+	backoffBase = 500 * time.Millisecond
+	backoffMax  = 30 * time.Second
+
+	// tradesPageLimit is the max number of trades gdax returns per page
+	tradesPageLimit = 100
+	// ordersPageLimit is the max number of orders gdax returns per page
+	ordersPageLimit = 100
+	// fillsPageLimit is the max number of fills gdax returns per page
+	fillsPageLimit = 100
+)
+
+// RequestError is returned when a request fails, after it has been retried
+// if the failure was because of rate limits, server, or network errors
+type RequestError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Attempts   int
+	Err        error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf(
+		"%s %s failed after %d attempts with status %d: %v",
+		e.Method,
+		e.URL,
+		e.Attempts,
+		e.StatusCode,
+		e.Err,
+	)
+}
+
+// Retryable checks if the request failed for a reason that could go away
+func (e *RequestError) Retryable() bool {
+	return e.StatusCode == 0 ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= 500
+}
+
+// Client wraps the exchange client so that all requests wait for the rate
+// limits and are retried with exponential backoff
+type Client struct {
+	client     *exchange.Client
+	public     *limiter
+	private    *limiter
+	MaxRetries int
+}
+
+// NewClient creates a rate limited client
+func NewClient(secret, key, passphrase string) *Client {
+	return &Client{
+		client:     exchange.NewClient(secret, key, passphrase),
+		public:     newLimiter(publicRate, publicBurst),
+		private:    newLimiter(privateRate, privateBurst),
+		MaxRetries: DefaultMaxRetries,
+	}
+}
+
+// Request sends a request once the rate limit allows it.
+// Rate limited requests are always retried, server and network errors are
+// only retried for private requests that are safe to repeat, so that we never
+// place an order twice.
+func (c *Client) Request(private bool, method, url string, params, result interface{}) error {
+	_, err := c.do(private, method, url, params, result)
+	return err
+}
+
+// do works like Request but also returns the response, for its headers
+func (c *Client) do(private bool, method, url string, params, result interface{}) (*http.Response, error) {
+	lim := c.public
+	if private {
+		lim = c.private
+	}
+	safe := !private || method == http.MethodGet
+	for attempt := 1; ; attempt++ {
+		lim.Wait()
+		res, err := c.client.Request(method, url, params, result)
+		if err == nil {
+			return res, nil
+		}
+		rerr := &RequestError{
+			Method:   method,
+			URL:      url,
+			Attempts: attempt,
+			Err:      err,
+		}
+		if res != nil {
+			rerr.StatusCode = res.StatusCode
+		}
+		if !rerr.Retryable() || attempt > c.MaxRetries {
+			return res, rerr
+		}
+		if !safe && rerr.StatusCode != http.StatusTooManyRequests {
+			return res, rerr
+		}
+		time.Sleep(backoff(attempt))
+	}
+}
+
+// backoff returns an exponential delay for the given attempt, with jitter so
+// that concurrent retries don't all hit at the same time
+func backoff(attempt int) time.Duration {
+	d := time.Duration(float64(backoffBase) * math.Pow(2, float64(attempt-1)))
+	if d > backoffMax || d <= 0 {
+		d = backoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ListTrades returns up to limit trades older than the given trade id, or the
+// latest ones if the id is 0
+func (c *Client) ListTrades(product string, after, limit int) ([]*market.Trade, error) {
+	if limit <= 0 || limit > tradesPageLimit {
+		limit = tradesPageLimit
+	}
+	url := fmt.Sprintf("/products/%s/trades?limit=%d", product, limit)
+	if after > 0 {
+		url = fmt.Sprintf("%s&after=%d", url, after)
+	}
+	trades := []*market.Trade{}
+	if err := c.Request(false, http.MethodGet, url, nil, &trades); err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+// GetHistoricRates returns candles between start and end, granularity is in
+// seconds
+func (c *Client) GetHistoricRates(product string, start, end time.Time, granularity int) ([]exchange.HistoricRate, error) {
+	url := fmt.Sprintf(
+		"/products/%s/candles?start=%s&end=%s&granularity=%d",
+		product,
+		start.UTC().Format(time.RFC3339),
+		end.UTC().Format(time.RFC3339),
+		granularity,
+	)
+	rates := []exchange.HistoricRate{}
+	if err := c.Request(false, http.MethodGet, url, nil, &rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// GetBook returns the book at the given level, 3 lists every order
+func (c *Client) GetBook(product string, level int) (exchange.Book, error) {
+	book := exchange.Book{}
+	url := fmt.Sprintf("/products/%s/book?level=%d", product, level)
+	err := c.Request(false, http.MethodGet, url, nil, &book)
+	return book, err
+}
+
+// CancelOrder -
+func (c *Client) CancelOrder(id string) error {
+	return c.Request(true, http.MethodDelete, fmt.Sprintf("/orders/%s", id), nil, nil)
+}
+
+// GetOrder -
+func (c *Client) GetOrder(id string) (exchange.Order, error) {
+	ord := exchange.Order{}
+	err := c.Request(true, http.MethodGet, fmt.Sprintf("/orders/%s", id), nil, &ord)
+	return ord, err
+}
+
+// CancelAll cancels all open orders for the product and returns their ids
+func (c *Client) CancelAll(product string) ([]string, error) {
+	ids := []string{}
+	url := fmt.Sprintf("/orders?product_id=%s", product)
+	err := c.Request(true, http.MethodDelete, url, nil, &ids)
+	return ids, err
+}
+
+// ListOrders returns all orders of the product with any of the given
+// statuses, going through all pages
+func (c *Client) ListOrders(product string, statuses ...string) ([]exchange.Order, error) {
+	query := fmt.Sprintf("product_id=%s&limit=%d", product, ordersPageLimit)
+	for _, st := range statuses {
+		query += "&status=" + st
+	}
+	orders := []exchange.Order{}
+	after := ""
+	for {
+		url := "/orders?" + query
+		if after != "" {
+			url += "&after=" + after
+		}
+		page := []exchange.Order{}
+		res, err := c.do(true, http.MethodGet, url, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page...)
+		if res == nil || len(page) < ordersPageLimit {
+			return orders, nil
+		}
+		after = res.Header.Get("CB-AFTER")
+		if after == "" {
+			return orders, nil
+		}
+	}
+}
+
+// Fill is one of our fills, Liquidity is M for maker and T for taker fills
+type Fill struct {
+	TradeID   int           `json:"trade_id"`
+	ProductID string        `json:"product_id"`
+	OrderID   string        `json:"order_id"`
+	Price     float64       `json:"price,string"`
+	Size      float64       `json:"size,string"`
+	Fee       float64       `json:"fee,string"`
+	Side      string        `json:"side"`
+	Liquidity string        `json:"liquidity"`
+	CreatedAt exchange.Time `json:"created_at"`
+}
+
+// ListFills returns all fills of an order, newest first, going through all
+// pages
+func (c *Client) ListFills(orderID string) ([]Fill, error) {
+	query := fmt.Sprintf("order_id=%s&limit=%d", orderID, fillsPageLimit)
+	fills := []Fill{}
+	after := ""
+	for {
+		url := "/fills?" + query
+		if after != "" {
+			url += "&after=" + after
+		}
+		page := []Fill{}
+		res, err := c.do(true, http.MethodGet, url, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		fills = append(fills, page...)
+		if res == nil || len(page) < fillsPageLimit {
+			return fills, nil
+		}
+		after = res.Header.Get("CB-AFTER")
+		if after == "" {
+			return fills, nil
+		}
+	}
+}
+
+// GetAccounts -
+func (c *Client) GetAccounts() ([]exchange.Account, error) {
+	acs := []exchange.Account{}
+	err := c.Request(true, http.MethodGet, "/accounts", nil, &acs)
+	return acs, err
+}
+
+// limiter is a token bucket that refills at rate tokens per second, up to
+// burst tokens
+type limiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate, burst float64) *limiter {
+	return &limiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available and takes it
+func (l *limiter) Wait() {
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// take the token now, going negative reserves it for later
+	l.tokens--
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.Unlock()
+	time.Sleep(wait)
+}
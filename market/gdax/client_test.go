@@ -0,0 +1,129 @@
+package gdax
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	lim := newLimiter(10, 2)
+	began := time.Now()
+	// the burst goes through at once
+	lim.Wait()
+	lim.Wait()
+	if took := time.Since(began); took > 50*time.Millisecond {
+		t.Errorf("expected the burst to not wait, took %s", took)
+	}
+	// the rest wait for the bucket to refill
+	lim.Wait()
+	lim.Wait()
+	if took := time.Since(began); took < 150*time.Millisecond || took > time.Second {
+		t.Errorf("expected to wait about 200ms after the burst, took %s", took)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		max := backoffBase << uint(attempt-1)
+		if max > backoffMax {
+			max = backoffMax
+		}
+		for i := 0; i < 100; i++ {
+			if d := backoff(attempt); d < max/2 || d > max {
+				t.Errorf("attempt %d: expected a delay between %s and %s, got %s", attempt, max/2, max, d)
+				break
+			}
+		}
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		private  bool
+		method   string
+		statuses []int
+		attempts int
+		status   int
+	}{
+		{
+			name:     "succeeds",
+			method:   http.MethodGet,
+			statuses: []int{http.StatusOK},
+			attempts: 1,
+		},
+		{
+			name:     "retries server errors",
+			method:   http.MethodGet,
+			statuses: []int{http.StatusInternalServerError, http.StatusOK},
+			attempts: 2,
+		},
+		{
+			name:     "gives up after the max retries",
+			method:   http.MethodGet,
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			attempts: 2,
+			status:   http.StatusBadGateway,
+		},
+		{
+			name:     "does not retry bad requests",
+			method:   http.MethodGet,
+			statuses: []int{http.StatusBadRequest, http.StatusOK},
+			attempts: 1,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:     "does not repeat private posts on server errors",
+			private:  true,
+			method:   http.MethodPost,
+			statuses: []int{http.StatusInternalServerError, http.StatusOK},
+			attempts: 1,
+			status:   http.StatusInternalServerError,
+		},
+		{
+			name:     "repeats private posts when rate limited",
+			private:  true,
+			method:   http.MethodPost,
+			statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			attempts: 2,
+		},
+	}
+	for _, test := range tests {
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := test.statuses[calls]
+			calls++
+			w.WriteHeader(status)
+			if status != http.StatusOK {
+				fmt.Fprint(w, `{"message":"nope"}`)
+				return
+			}
+			fmt.Fprint(w, `{}`)
+		}))
+		c := NewClient("", "", "")
+		c.client.BaseURL = srv.URL
+		c.MaxRetries = 1
+		err := c.Request(test.private, test.method, "/test", nil, &struct{}{})
+		srv.Close()
+		if calls != test.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", test.name, test.attempts, calls)
+		}
+		if test.status == 0 {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", test.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(*RequestError)
+		if !ok {
+			t.Errorf("%s: expected a request error, got %v", test.name, err)
+			continue
+		}
+		if rerr.StatusCode != test.status || rerr.Attempts != test.attempts {
+			t.Errorf("%s: expected status %d after %d attempts, got %d after %d", test.name, test.status, test.attempts, rerr.StatusCode, rerr.Attempts)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package gdax
+
+import (
+	"strings"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+// feeShareTiers is gdax's fee schedule as it was published on gdax.com/fees
+// from 2017 until the 2019 change, tiers are picked by our share of the
+// product's trailing 30 day volume. Makers paid nothing.
+var feeShareTiers = []market.FeeTier{
+	{Volume: 0, Maker: 0, Taker: 0.003},
+	{Volume: 0.01, Maker: 0, Taker: 0.0024},
+	{Volume: 0.025, Maker: 0, Taker: 0.0022},
+	{Volume: 0.05, Maker: 0, Taker: 0.0019},
+	{Volume: 0.1, Maker: 0, Taker: 0.0015},
+	{Volume: 0.2, Maker: 0, Taker: 0.001},
+}
+
+// btcFirstTierTaker is the first tier taker fee of BTC products, which were
+// cheaper than the rest of the products
+const btcFirstTierTaker = 0.0025
+
+// Fees returns an approximation of gdax's fee schedule for the product, with
+// volume being our trailing 30 day volume in quote currency before we started
+// trading, and marketVolume the product's trailing 30 day volume in quote
+// currency.
+// gdax tiers on our share of the market's volume, which moves with the
+// market, here the market volume is assumed to stay the same so that the
+// tiers become fixed volumes. Without a market volume only the first tier
+// applies.
+func Fees(product string, volume, marketVolume float64) *market.FeeSchedule {
+	tiers := []market.FeeTier{}
+	for _, t := range feeShareTiers {
+		if t.Volume > 0 && marketVolume <= 0 {
+			break
+		}
+		tier := market.FeeTier{
+			Volume: t.Volume * marketVolume,
+			Maker:  t.Maker,
+			Taker:  t.Taker,
+		}
+		if t.Volume == 0 && strings.HasPrefix(strings.ToUpper(product), "BTC-") {
+			tier.Taker = btcFirstTierTaker
+		}
+		tiers = append(tiers, tier)
+	}
+	return market.NewFeeSchedule(volume, tiers...)
+}
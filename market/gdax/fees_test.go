@@ -0,0 +1,36 @@
+package gdax
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+func TestFees(t *testing.T) {
+	at := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		product      string
+		volume       float64
+		marketVolume float64
+		taker        float64
+	}{
+		{"btc first tier", "BTC-USD", 0, 0, 0.0025},
+		{"eth first tier", "ETH-USD", 0, 0, 0.003},
+		{"lower case btc", "btc-eur", 0, 0, 0.0025},
+		{"no market volume", "BTC-USD", 1e9, 0, 0.0025},
+		{"btc second tier", "BTC-USD", 1e6, 1e8, 0.0024},
+		{"eth top tier", "ETH-USD", 3e7, 1e8, 0.001},
+	}
+	for _, test := range tests {
+		fees := Fees(test.product, test.volume, test.marketVolume)
+		if taker := fees.Rate(market.Taker, at); math.Abs(taker-test.taker) > 1e-9 {
+			t.Errorf("%s: expected a taker fee of %v, got %v", test.name, test.taker, taker)
+		}
+		if maker := fees.Rate(market.Maker, at); maker != 0 {
+			t.Errorf("%s: expected no maker fee, got %v", test.name, maker)
+		}
+	}
+}
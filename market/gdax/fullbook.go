@@ -0,0 +1,295 @@
+package gdax
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	logrus "github.com/sirupsen/logrus"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	// fullBookLevel is the rest book level that lists every order
+	fullBookLevel = 3
+)
+
+var (
+	ErrorSnapshotTooOld = errors.New("Snapshot is older than the buffered messages")
+)
+
+// fullBookOrder is an order resting in the book
+type fullBookOrder struct {
+	id    string
+	side  market.Action
+	price float64
+	size  float64
+}
+
+// FullBook rebuilds the order by order book from the full channel.
+// Messages are buffered until a rest snapshot is loaded, and sequence gaps
+// cause the book to be reloaded. A later snapshot can be used to validate
+// the book once it has caught up with the snapshot's sequence.
+type FullBook struct {
+	sync.RWMutex
+	synced   bool
+	loading  bool
+	sequence int
+	orders   map[string]*fullBookOrder
+	// levels holds the orders of each side by price, in the order they
+	// were placed
+	levels map[market.Action]map[float64][]*fullBookOrder
+	buffer []*Message
+	check  *exchange.Book
+}
+
+// NewFullBook creates an empty book that needs a snapshot
+func NewFullBook() *FullBook {
+	b := &FullBook{}
+	b.reset()
+	return b
+}
+
+// Reset empties the book, it will need a new snapshot
+func (b *FullBook) Reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.reset()
+}
+
+func (b *FullBook) reset() {
+	b.synced = false
+	b.loading = false
+	b.sequence = 0
+	b.orders = map[string]*fullBookOrder{}
+	b.levels = map[market.Action]map[float64][]*fullBookOrder{
+		market.Buy:  map[float64][]*fullBookOrder{},
+		market.Sell: map[float64][]*fullBookOrder{},
+	}
+	b.buffer = []*Message{}
+	b.check = nil
+}
+
+// Synced checks if the book has loaded a snapshot and is up to date
+func (b *FullBook) Synced() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.synced
+}
+
+// Apply updates the book with a message from the full channel, returns true
+// when a snapshot needs to be loaded
+func (b *FullBook) Apply(message *Message) bool {
+	if message.Sequence == 0 {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	if !b.synced {
+		b.buffer = append(b.buffer, message)
+		if b.loading {
+			return false
+		}
+		b.loading = true
+		return true
+	}
+	if message.Sequence <= b.sequence {
+		return false
+	}
+	if message.Sequence > b.sequence+1 {
+		logrus.
+			WithField("expected", b.sequence+1).
+			WithField("got", message.Sequence).
+			Warnf("Full book sequence gap, reloading")
+		b.reset()
+		b.buffer = append(b.buffer, message)
+		b.loading = true
+		return true
+	}
+	b.apply(message)
+	if b.check != nil && b.check.Sequence == b.sequence {
+		b.validate(*b.check)
+		b.check = nil
+	}
+	return false
+}
+
+// apply a message in sequence, must be called while holding the lock
+func (b *FullBook) apply(message *Message) {
+	b.sequence = message.Sequence
+	switch message.Type {
+	case "open":
+		b.add(&fullBookOrder{
+			id:    message.OrderID,
+			side:  side(message.Side),
+			price: message.Price,
+			size:  message.RemainingSize,
+		})
+	case "done":
+		b.remove(message.OrderID)
+	case "match":
+		if o, ok := b.orders[message.MakerOrderID]; ok {
+			o.size -= message.Size
+		}
+	case "change":
+		// only orders resting in the book can change, and reducing the
+		// size keeps their place in the queue
+		if o, ok := b.orders[message.OrderID]; ok && message.NewSize > 0 {
+			o.size = message.NewSize
+		}
+	}
+}
+
+func (b *FullBook) add(o *fullBookOrder) {
+	b.orders[o.id] = o
+	b.levels[o.side][o.price] = append(b.levels[o.side][o.price], o)
+}
+
+func (b *FullBook) remove(id string) {
+	o, ok := b.orders[id]
+	if !ok {
+		return
+	}
+	delete(b.orders, id)
+	queue := b.levels[o.side][o.price]
+	for i, q := range queue {
+		if q == o {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(b.levels[o.side], o.price)
+		return
+	}
+	b.levels[o.side][o.price] = queue
+}
+
+// Load replaces the book with a level 3 snapshot and applies the messages
+// that came after it
+func (b *FullBook) Load(snapshot exchange.Book) error {
+	b.Lock()
+	defer b.Unlock()
+	buffer := b.buffer
+	b.reset()
+	b.load(snapshot)
+	for _, message := range buffer {
+		if message.Sequence <= b.sequence {
+			continue
+		}
+		if message.Sequence > b.sequence+1 {
+			// we missed messages between the snapshot and the buffer,
+			// keep buffering and try again
+			b.reset()
+			b.buffer = buffer
+			return ErrorSnapshotTooOld
+		}
+		b.apply(message)
+	}
+	b.synced = true
+	return nil
+}
+
+// load a snapshot, must be called while holding the lock
+func (b *FullBook) load(snapshot exchange.Book) {
+	for _, e := range snapshot.Bids {
+		b.add(&fullBookOrder{
+			id:    e.OrderId,
+			side:  market.Buy,
+			price: e.Price,
+			size:  e.Size,
+		})
+	}
+	for _, e := range snapshot.Asks {
+		b.add(&fullBookOrder{
+			id:    e.OrderId,
+			side:  market.Sell,
+			price: e.Price,
+			size:  e.Size,
+		})
+	}
+	b.sequence = snapshot.Sequence
+}
+
+// LoadFailed allows Apply to ask for a snapshot again
+func (b *FullBook) LoadFailed() {
+	b.Lock()
+	defer b.Unlock()
+	b.loading = false
+}
+
+// Validate compares the book with a snapshot once the book reaches the
+// snapshot's sequence, and replaces the book with the snapshot if they differ.
+// Snapshots older than the book are ignored.
+func (b *FullBook) Validate(snapshot exchange.Book) {
+	b.Lock()
+	defer b.Unlock()
+	if !b.synced || snapshot.Sequence < b.sequence {
+		return
+	}
+	if snapshot.Sequence == b.sequence {
+		b.validate(snapshot)
+		return
+	}
+	b.check = &snapshot
+}
+
+// validate a snapshot of the same sequence, must be called while holding
+// the lock
+func (b *FullBook) validate(snapshot exchange.Book) {
+	seen := map[string]bool{}
+	diff := 0
+	for _, entries := range [][]exchange.BookEntry{snapshot.Bids, snapshot.Asks} {
+		for _, e := range entries {
+			seen[e.OrderId] = true
+			o, ok := b.orders[e.OrderId]
+			if !ok || o.price != e.Price || math.Abs(o.size-e.Size) > 1e-8 {
+				diff++
+			}
+		}
+	}
+	for id := range b.orders {
+		if !seen[id] {
+			diff++
+		}
+	}
+	if diff == 0 {
+		logrus.WithField("sequence", b.sequence).Debugf("Full book is valid")
+		return
+	}
+	logrus.
+		WithField("sequence", b.sequence).
+		WithField("orders", diff).
+		Warnf("Full book differs from snapshot, replacing it")
+	b.reset()
+	b.load(snapshot)
+	b.synced = true
+}
+
+// QueuePosition estimates the size resting ahead of an order at its price
+func (b *FullBook) QueuePosition(orderID string) (float64, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	o, ok := b.orders[orderID]
+	if !ok {
+		return 0, false
+	}
+	ahead := 0.0
+	for _, q := range b.levels[o.side][o.price] {
+		if q == o {
+			return ahead, true
+		}
+		ahead += q.size
+	}
+	return 0, false
+}
+
+// side converts a message side to an action
+func side(s string) market.Action {
+	if s == "buy" {
+		return market.Buy
+	}
+	return market.Sell
+}
@@ -0,0 +1,128 @@
+package gdax
+
+import (
+	"testing"
+
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+func TestFullBookApply(t *testing.T) {
+	snapshot := exchange.Book{
+		Sequence: 10,
+		Bids: []exchange.BookEntry{
+			{OrderId: "b1", Price: 100, Size: 1},
+			{OrderId: "b2", Price: 100, Size: 2},
+		},
+		Asks: []exchange.BookEntry{
+			{OrderId: "a1", Price: 101, Size: 1},
+		},
+	}
+	tests := []struct {
+		name string
+		// before are applied before the snapshot is loaded
+		before []*Message
+		after  []*Message
+		synced bool
+		reload bool
+		// queue is the size ahead of b2, -1 if b2 is not in the book
+		queue float64
+	}{
+		{
+			name:   "snapshot",
+			synced: true,
+			queue:  1,
+		},
+		{
+			name: "buffered messages newer than the snapshot",
+			before: []*Message{
+				{Sequence: 9, Type: "done", OrderID: "b1"},
+				{Sequence: 11, Type: "match", MakerOrderID: "b1", Size: 0.5},
+			},
+			synced: true,
+			queue:  0.5,
+		},
+		{
+			name: "messages in sequence",
+			after: []*Message{
+				{Sequence: 11, Type: "open", OrderID: "b3", Side: "buy", Price: 100, RemainingSize: 1},
+				{Sequence: 12, Type: "done", OrderID: "b1"},
+			},
+			synced: true,
+			queue:  0,
+		},
+		{
+			name: "old messages are skipped",
+			after: []*Message{
+				{Sequence: 10, Type: "done", OrderID: "b1"},
+			},
+			synced: true,
+			queue:  1,
+		},
+		{
+			name: "change keeps the queue",
+			after: []*Message{
+				{Sequence: 11, Type: "change", OrderID: "b1", NewSize: 0.2},
+			},
+			synced: true,
+			queue:  0.2,
+		},
+		{
+			name: "gap",
+			after: []*Message{
+				{Sequence: 12, Type: "done", OrderID: "b1"},
+			},
+			reload: true,
+			queue:  -1,
+		},
+	}
+	for _, test := range tests {
+		b := NewFullBook()
+		for _, m := range test.before {
+			b.Apply(m)
+		}
+		if err := b.Load(snapshot); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		reload := false
+		for _, m := range test.after {
+			if b.Apply(m) {
+				reload = true
+			}
+		}
+		if reload != test.reload {
+			t.Errorf("%s: expected reload %v, got %v", test.name, test.reload, reload)
+		}
+		if b.Synced() != test.synced {
+			t.Errorf("%s: expected synced %v, got %v", test.name, test.synced, b.Synced())
+		}
+		queue, ok := b.QueuePosition("b2")
+		if !ok {
+			queue = -1
+		}
+		if queue != test.queue {
+			t.Errorf("%s: expected %v ahead of b2, got %v", test.name, test.queue, queue)
+		}
+	}
+}
+
+func TestFullBookSnapshotTooOld(t *testing.T) {
+	b := NewFullBook()
+	if !b.Apply(&Message{Sequence: 15, Type: "done", OrderID: "b1"}) {
+		t.Fatalf("expected the first message to ask for a snapshot")
+	}
+	if b.Apply(&Message{Sequence: 16, Type: "done", OrderID: "b2"}) {
+		t.Errorf("expected a single snapshot request while loading")
+	}
+	if err := b.Load(exchange.Book{Sequence: 10}); err != ErrorSnapshotTooOld {
+		t.Errorf("expected the snapshot to be too old, got %v", err)
+	}
+	if b.Synced() {
+		t.Errorf("expected the book to wait for a newer snapshot")
+	}
+	if err := b.Load(exchange.Book{Sequence: 14}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !b.Synced() {
+		t.Errorf("expected the book to be synced")
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	uuid "github.com/google/uuid"
@@ -25,10 +27,35 @@ import (
 const (
 	// Name of the market
 	Name = "gdax"
+
+	wsURL          = "wss://ws-feed.gdax.com"
+	wsReadTimeout  = 30 * time.Second
+	wsWriteTimeout = 5 * time.Second
+	wsPingInterval = 10 * time.Second
+	// wsStableAfter is how long a connection needs to stay up for the
+	// reconnection backoff to be reset
+	wsStableAfter = time.Minute
+	// wsMaxRecoveredTrades limits how far back we go for missed trades
+	wsMaxRecoveredTrades = 10000
+	// fullBookValidateInterval is how often the full book is checked
+	// against a rest snapshot
+	fullBookValidateInterval = 5 * time.Minute
+	// accountReconcileInterval is how often our balances are reset from
+	// the ones gdax reports
+	accountReconcileInterval = time.Minute
+	// workBuffer is how many jobs can wait for the worker before the feed
+	// is held up
+	workBuffer = 1000
+	// book updates are persisted in batches, and dropped if persistence
+	// can't keep up
+	bookRecordBuffer   = 10000
+	bookRecordBatch    = 1000
+	bookRecordInterval = time.Second
 )
 
 var (
 	ErrorOrderRejected = errors.New("Order rejected")
+	ErrorOrderNotFound = errors.New("Order not found")
 )
 
 // gdax -
@@ -36,26 +63,64 @@ type gdax struct {
 	product        string
 	handlers       []market.TradeHandler
 	updateHandlers []market.UpdateHandler
-	client         *exchange.Client
+	client         *Client
 	persistence    persistence.Persistence
 
 	secret     string
 	key        string
 	passphrase string
 
-	balanceCacheValid    bool
-	balanceCacheAsset    float64
-	balanceCacheCurrency float64
+	// portfolio follows our balances from our order updates, and is
+	// reconciled with gdax's accounts
+	portfolio *market.Portfolio
+	// reconciled is set to 1, atomically, once we have gotten our accounts
+	reconciled int32
 
 	profileID string
-	clientOID string
 
-	openOrders     map[string]*exchange.Order
+	// openOrders are the orders we have placed that are not done yet, and
+	// cancelling the ones we have asked to be cancelled
+	openOrders map[string]*market.Order
+	cancelling map[string]bool
+	// placing holds the messages of the orders we are placing that came
+	// before gdax answered, by client order id, and early maps the ids of
+	// these orders to their client order ids
+	placing map[string][]*Message
+	early   map[string]string
+	// fills holds the trade ids of each open order's fills we have seen,
+	// with the fee we estimated for them
+	fills          map[string]map[int]float64
 	openOrdersLock sync.RWMutex
+	// fees estimates the fees of our fills until gdax tells us what they were
+	fees market.FeeModel
+	// work holds the jobs that need rest requests, so that they don't hold
+	// up the feed, they are run one at a time by the worker
+	work chan func()
+
+	// sequence is the last message sequence of the current connection
+	sequence int
+	// lastTradeID is the last trade we sent to the handlers
+	lastTradeID int
+
+	// book is maintained from the level2 channel
+	book *market.Book
+	// fullBook is maintained from the full channel
+	fullBook *FullBook
+	// bookRecords are the book updates waiting to be persisted, nil if we
+	// are not recording the book
+	bookRecords chan *market.BookUpdate
+	// bookSequence is the sequence of the last book update we recorded, it
+	// starts from when we were created so that it keeps going up across
+	// restarts
+	bookSequence int64
+	// bookSnapshot is the last level2 snapshot, until the change that
+	// follows it gives it a time
+	bookSnapshot *market.BookUpdate
 }
 
-// New gdax market
-func New(persistence persistence.Persistence, product string) (market.Market, error) {
+// New gdax market, if recordBook is set the level2 book updates we receive
+// are put in the persistence
+func New(persistence persistence.Persistence, product string, recordBook bool) (market.Market, error) {
 	secret := os.Getenv("COINBASE_SECRET")
 	key := os.Getenv("COINBASE_KEY")
 	passphrase := os.Getenv("COINBASE_PASSPHRASE")
@@ -65,23 +130,42 @@ func New(persistence persistence.Persistence, product string) (market.Market, er
 	mrk := &gdax{
 		product:     strings.ToUpper(product),
 		handlers:    []market.TradeHandler{},
-		client:      exchange.NewClient(secret, key, passphrase),
+		client:      NewClient(secret, key, passphrase),
 		persistence: persistence,
 		secret:      secret,
 		key:         key,
 		passphrase:  passphrase,
-		openOrders:  map[string]*exchange.Order{},
+		openOrders:  map[string]*market.Order{},
+		cancelling:  map[string]bool{},
+		placing:     map[string][]*Message{},
+		early:       map[string]string{},
+		fills:       map[string]map[int]float64{},
+		fees:        Fees(product, 0, 0),
+		work:        make(chan func(), workBuffer),
+		book:        market.NewBook(),
+		fullBook:    NewFullBook(),
+	}
+	mrk.portfolio = market.NewPortfolio(mrk.asset(), mrk.currency())
+	go mrk.worker()
+	if recordBook {
+		mrk.bookRecords = make(chan *market.BookUpdate, bookRecordBuffer)
+		mrk.bookSequence = time.Now().UnixNano()
+		go mrk.recordBook()
 	}
 
 	return mrk, nil
 }
 
-func (m *gdax) Listen() {
+// Listen connects to the websocket feed and handles messages until the
+// connection fails, trades we missed while disconnected or because of
+// sequence gaps are fetched over rest
+func (m *gdax) Listen() error {
 	var wsDialer ws.Dialer
-	wsConn, _, err := wsDialer.Dial("wss://ws-feed.gdax.com", nil)
+	wsConn, _, err := wsDialer.Dial(wsURL, nil)
 	if err != nil {
-		println(err.Error())
+		return err
 	}
+	defer wsConn.Close()
 
 	time.Sleep(time.Second)
 	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
@@ -89,110 +173,473 @@ func (m *gdax) Listen() {
 	if err != nil {
 		logrus.WithError(err).Fatalf("Could not subscribe to gdax ws")
 	}
-	subscribe := map[string]string{
-		"type":       "subscribe",
-		"product_id": m.product,
-		"signature":  signature,
-		"key":        m.key,
-		"passphrase": m.passphrase,
-		"timestamp":  timestamp,
+	subscribe := map[string]interface{}{
+		"type":        "subscribe",
+		"product_ids": []string{m.product},
+		"channels":    []string{"full", "level2"},
+		"signature":   signature,
+		"key":         m.key,
+		"passphrase":  m.passphrase,
+		"timestamp":   timestamp,
 	}
 	if err := wsConn.WriteJSON(subscribe); err != nil {
-		println("gdax ws sub error", err.Error())
+		return err
 	}
 
-	message := Message{}
+	// gdax answers our pings, if we don't hear anything for a while the
+	// connection is dead
+	wsConn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	wsConn.SetPongHandler(func(string) error {
+		return wsConn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go m.ping(wsConn, done)
+	go m.validateFullBook(done)
+
+	// sequences start over with every connection
+	m.sequence = 0
+	m.fullBook.Reset()
+	// anything that happened to our orders from now on comes through the
+	// feed, and fills we see twice are skipped
+	m.work <- m.reconcileOrders
+	m.work <- func() {
+		if err := m.reconcileAccount(); err != nil {
+			logrus.WithError(err).Warnf("Could not get accounts")
+		}
+	}
+	catchUp := m.lastTradeID > 0
 	for {
-		if err := wsConn.ReadJSON(&message); err != nil {
-			logrus.WithError(err).Errorf("gdax ws read error")
-			break
+		message := &Message{}
+		if err := wsConn.ReadJSON(message); err != nil {
+			return err
 		}
-		m.openOrdersLock.Lock()
-		if message.Type == "error" {
-			logrus.WithField("message", message).Errorf("GDAX Error")
-		} else if _, ok := m.openOrders[message.OrderID]; ok {
-			// this is our own order
-			// if the order has been filled publish an update
-			// TODO the type=match is not tested
-			if message.Type == "match" {
-				logrus.
-					WithField("message", message).
-					Warnf("Our order -- MATCHED")
-				act := market.Sell
-				if message.Side == "buy" {
-					act = market.Buy
-				}
-				upd := &market.Update{
-					Action: act,
-					Price:  message.Price,
-					Size:   message.Size,
-					Time:   message.Time.Time(),
-				}
-				// logrus.WithField("update", upd).Warnf("Update on match")
-				// TODO move to channels
-				for _, h := range m.updateHandlers {
-					if h != nil {
-						h.HandleUpdate(upd) // TODO Handle error
-					}
-				}
-			} else if message.Type == "done" {
-				// if the order has been filled, publish an update
-				if message.Reason == "filled" {
-					act := market.Sell
-					if message.Side == "buy" {
-						act = market.Buy
-					}
-					upd := &market.Update{
-						Action: act,
-						Price:  message.Price,
-						Size:   message.Size,
-						Time:   message.Time.Time(),
-					}
-					// TODO move to channels
-					for _, h := range m.updateHandlers {
-						if h != nil {
-							h.HandleUpdate(upd) // TODO Handle error
-						}
-					}
-				} else {
-					// report event
-					upd := &market.Update{
-						Action: market.Cancel,
-						Price:  message.Price,
-						Size:   message.Size,
-						Time:   message.Time.Time(),
-					}
-					// TODO move to channels
-					for _, h := range m.updateHandlers {
-						if h != nil {
-							h.HandleUpdate(upd) // TODO Handle error
-						}
-					}
-				}
-				// and remove from orders
-				delete(m.openOrders, message.OrderID)
+		wsConn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		// fill in what we missed while reconnecting, now that the new
+		// stream has started
+		if catchUp {
+			catchUp = false
+			m.recoverTrades()
+		}
+		if !m.checkSequence(message) {
+			continue
+		}
+		if m.fullBook.Apply(message) {
+			go m.loadFullBook()
+		}
+		m.handleMessage(message)
+	}
+}
+
+// worker runs the queued jobs one at a time
+func (m *gdax) worker() {
+	for job := range m.work {
+		job()
+	}
+}
+
+// ping keeps pinging the connection until done is closed
+func (m *gdax) ping(wsConn *ws.Conn, done chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(wsWriteTimeout)
+			if err := wsConn.WriteControl(ws.PingMessage, nil, deadline); err != nil {
+				logrus.WithError(err).Warnf("Could not ping gdax ws")
+				return
 			}
-		} else if message.ClientOID == m.clientOID {
-			// our own orders
-		} else if message.Type == "match" {
-			t := &market.Trade{
-				ID:      fmt.Sprintf("%s.%s.%d", Name, m.product, message.TradeID),
-				Market:  Name,
-				Product: m.product,
-				TradeID: message.TradeID,
-				Price:   message.Price,
-				Size:    message.Size,
-				Time:    message.Time.Time(),
-				Side:    message.Side,
+		}
+	}
+}
+
+// loadFullBook loads a level 3 snapshot into the full book
+func (m *gdax) loadFullBook() {
+	book, err := m.client.GetBook(m.product, fullBookLevel)
+	if err != nil {
+		logrus.WithError(err).Warnf("Could not get full book snapshot")
+		m.fullBook.LoadFailed()
+		return
+	}
+	if err := m.fullBook.Load(book); err != nil {
+		logrus.WithError(err).Warnf("Could not load full book snapshot")
+		m.fullBook.LoadFailed()
+		return
+	}
+	logrus.WithField("sequence", book.Sequence).Infof("Loaded full book")
+}
+
+// validateFullBook periodically checks the full book against a snapshot
+// until done is closed
+func (m *gdax) validateFullBook(done chan struct{}) {
+	ticker := time.NewTicker(fullBookValidateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !m.fullBook.Synced() {
+				continue
 			}
-			// TODO move to channels
-			for _, h := range m.handlers {
-				if h != nil {
-					h.HandleTrade(t) // TODO Handle error
-				}
+			book, err := m.client.GetBook(m.product, fullBookLevel)
+			if err != nil {
+				logrus.WithError(err).Warnf("Could not get full book snapshot")
+				continue
 			}
+			m.fullBook.Validate(book)
 		}
+	}
+}
+
+// QueuePosition estimates the size resting ahead of one of our orders
+func (m *gdax) QueuePosition(orderID string) (float64, bool) {
+	return m.fullBook.QueuePosition(orderID)
+}
+
+// checkSequence makes sure messages come in order, returns false for messages
+// we have already seen and recovers missed trades on gaps
+func (m *gdax) checkSequence(message *Message) bool {
+	seq := message.Sequence
+	if seq == 0 {
+		// not part of the feed, eg. errors
+		return true
+	}
+	if m.sequence > 0 && seq <= m.sequence {
+		return false
+	}
+	if m.sequence > 0 && seq > m.sequence+1 {
+		logrus.
+			WithField("expected", m.sequence+1).
+			WithField("got", seq).
+			Warnf("gdax ws sequence gap")
+		m.sequence = seq
+		m.recoverTrades()
+		return true
+	}
+	m.sequence = seq
+	return true
+}
+
+// handleMessage turns a message into updates for our orders or trades, the
+// lock is never held while calling handlers as they might place orders
+func (m *gdax) handleMessage(message *Message) {
+	switch message.Type {
+	case "error":
+		logrus.WithField("message", message).Errorf("GDAX Error")
+		return
+	case "snapshot":
+		upd := &market.BookUpdate{
+			Market:  Name,
+			Product: m.product,
+			Type:    market.BookSnapshot,
+			Time:    message.Time.Time(),
+			Bids:    parseLevels(message.Bids),
+			Asks:    parseLevels(message.Asks),
+		}
+		upd.Apply(m.book)
+		// snapshots don't have a time, they are recorded with the time of
+		// the first change after them so that recordings only use gdax's
+		// clock
+		if upd.Time.IsZero() {
+			m.bookSnapshot = upd
+			return
+		}
+		m.record(upd)
+		return
+	case "l2update":
+		upd := &market.BookUpdate{
+			Market:  Name,
+			Product: m.product,
+			Type:    market.BookChange,
+			Time:    message.Time.Time(),
+			Bids:    []market.BookLevel{},
+			Asks:    []market.BookLevel{},
+		}
+		for _, c := range message.Changes {
+			if len(c) != 3 {
+				continue
+			}
+			prc, _ := strconv.ParseFloat(c[1], 64)
+			sz, _ := strconv.ParseFloat(c[2], 64)
+			l := market.BookLevel{
+				Price: prc,
+				Size:  sz,
+			}
+			if c[0] == "buy" {
+				upd.Bids = append(upd.Bids, l)
+			} else {
+				upd.Asks = append(upd.Asks, l)
+			}
+		}
+		upd.Apply(m.book)
+		if m.bookSnapshot != nil {
+			m.bookSnapshot.Time = upd.Time
+			m.record(m.bookSnapshot)
+			m.bookSnapshot = nil
+		}
+		m.record(upd)
+		return
+	}
+	m.handleOrderMessage(message)
+	if message.Type == "match" {
+		m.handleTrade(&market.Trade{
+			ID:      fmt.Sprintf("%s.%s.%d", Name, m.product, message.TradeID),
+			Market:  Name,
+			Product: m.product,
+			TradeID: message.TradeID,
+			Price:   message.Price,
+			Size:    message.Size,
+			Time:    message.Time.Time(),
+			Side:    message.Side,
+		})
+	}
+}
+
+// record queues a book update to be persisted, if we are recording, and
+// gives it the next sequence
+func (m *gdax) record(upd *market.BookUpdate) {
+	if m.bookRecords == nil {
+		return
+	}
+	m.bookSequence++
+	upd.Sequence = m.bookSequence
+	select {
+	case m.bookRecords <- upd:
+	default:
+		logrus.Warnf("Book recording can't keep up, dropping update")
+	}
+}
+
+// recordBook persists the queued book updates in batches
+func (m *gdax) recordBook() {
+	ticker := time.NewTicker(bookRecordInterval)
+	defer ticker.Stop()
+	batch := []*market.BookUpdate{}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := m.persistence.PutBookUpdate(batch...); err != nil {
+			logrus.WithError(err).Warnf("Could not put book updates")
+		}
+		batch = []*market.BookUpdate{}
+	}
+	for {
+		select {
+		case upd := <-m.bookRecords:
+			batch = append(batch, upd)
+			if len(batch) >= bookRecordBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// parseLevels parses the [price, size] pairs of a level2 snapshot
+func parseLevels(raw [][]string) []market.BookLevel {
+	levels := []market.BookLevel{}
+	for _, r := range raw {
+		if len(r) < 2 {
+			continue
+		}
+		prc, _ := strconv.ParseFloat(r[0], 64)
+		sz, _ := strconv.ParseFloat(r[1], 64)
+		levels = append(levels, market.BookLevel{
+			Price: prc,
+			Size:  sz,
+		})
+	}
+	return levels
+}
+
+// handleOrderMessage finds the order a message is about and applies it,
+// messages about orders we are still placing are buffered until we know them
+func (m *gdax) handleOrderMessage(message *Message) {
+	m.openOrdersLock.Lock()
+	if m.buffer(message) {
+		m.openOrdersLock.Unlock()
+		return
+	}
+	ord, ok := m.openOrders[message.OrderID]
+	if message.Type == "match" {
+		ord, ok = m.openOrders[message.MakerOrderID]
+		if !ok {
+			ord, ok = m.openOrders[message.TakerOrderID]
+		}
+	}
+	m.openOrdersLock.Unlock()
+	if !ok {
+		return
+	}
+	m.applyOrderMessage(ord, message)
+}
+
+// buffer keeps the messages of an order we are placing, which gdax can send
+// before answering us, starting from the received message that carries our
+// client order id. Must be called while holding the orders lock.
+func (m *gdax) buffer(message *Message) bool {
+	if message.Type == "received" {
+		if _, ok := m.placing[message.ClientOID]; ok {
+			m.early[message.OrderID] = message.ClientOID
+		}
+	}
+	for _, id := range []string{message.OrderID, message.MakerOrderID, message.TakerOrderID} {
+		if coid, ok := m.early[id]; ok && id != "" {
+			m.placing[coid] = append(m.placing[coid], message)
+			return true
+		}
+	}
+	return false
+}
+
+// stopPlacing drops the buffered messages of an order we were placing, must
+// be called while holding the orders lock
+func (m *gdax) stopPlacing(clientOID string) {
+	delete(m.placing, clientOID)
+	for id, coid := range m.early {
+		if coid == clientOID {
+			delete(m.early, id)
+		}
+	}
+}
+
+// applyOrderMessage moves an order through its statuses and publishes an
+// update for each transition. Fills only come from match messages, done
+// messages only end the order unless we missed some of its matches, which
+// are fetched by the worker. Matches we have already seen over rest are
+// skipped. Returns true if gdax is done with the order.
+func (m *gdax) applyOrderMessage(ord *market.Order, message *Message) bool {
+	liquidity := market.Maker
+	if message.Type == "match" && message.TakerOrderID == ord.ID {
+		liquidity = market.Taker
+	}
+	at := message.Time.Time()
+	m.openOrdersLock.Lock()
+	var upd *market.Update
+	switch message.Type {
+	case "open":
+		if ord.Status == market.OrderPending {
+			ord.Open() // TODO Handle error
+			upd = ord.Update(market.Activate, ord.Price, ord.Remaining(), at)
+		}
+	case "match":
+		if _, seen := m.fills[ord.ID][message.TradeID]; seen {
+			break
+		}
+		fee := m.fees.Charge(liquidity, message.Size, message.Price, at)
+		if err := ord.Fill(message.Size, message.Price, fee); err != nil {
+			logrus.WithError(err).WithField("order", ord.ID).Warnf("Could not fill order")
+			break
+		}
+		m.seeFill(ord.ID, message.TradeID, fee)
+		upd = ord.Update(ord.Side, message.Price, message.Size, at)
+		upd.Fee = fee
+		upd.Liquidity = liquidity
+	case "done":
+		delete(m.openOrders, ord.ID)
+		filled := ord.FilledSize > 0
 		m.openOrdersLock.Unlock()
+		m.work <- func() {
+			// gdax forgets cancelled orders that were never filled
+			if message.Reason == "filled" || filled {
+				m.catchUpFills(ord)
+			}
+			cancelled := m.forget(ord.ID)
+			if message.Reason != "filled" {
+				m.endOrder(ord, at, cancelled)
+			}
+		}
+		return true
+	}
+	m.openOrdersLock.Unlock()
+	if upd != nil {
+		m.notify(upd)
+	}
+	return false
+}
+
+// handleTrade sends a trade to the trade handlers, unless we have already
+// sent it or a newer one
+func (m *gdax) handleTrade(t *market.Trade) {
+	if t.TradeID <= m.lastTradeID {
+		return
+	}
+	m.lastTradeID = t.TradeID
+	// TODO move to channels
+	for _, h := range m.handlers {
+		if h != nil {
+			h.HandleTrade(t) // TODO Handle error
+		}
+	}
+}
+
+// recoverTrades fetches the trades after the last one we have seen over rest
+// and sends them to the handlers, oldest first
+func (m *gdax) recoverTrades() {
+	if m.lastTradeID == 0 {
+		return
+	}
+	missed := []*market.Trade{}
+	after := 0
+	for {
+		trades, err := m.client.ListTrades(m.product, after, tradesPageLimit)
+		if err != nil {
+			logrus.WithError(err).Warnf("Could not recover missed trades")
+			break
+		}
+		if len(trades) == 0 {
+			break
+		}
+		caught := false
+		for _, t := range trades {
+			if t.TradeID <= m.lastTradeID {
+				caught = true
+				break
+			}
+			missed = append(missed, t)
+		}
+		if caught {
+			break
+		}
+		if len(missed) >= wsMaxRecoveredTrades {
+			logrus.
+				WithField("trades", len(missed)).
+				Warnf("Too many missed trades, only recovering the latest")
+			break
+		}
+		after = trades[len(trades)-1].TradeID
+	}
+	if len(missed) == 0 {
+		return
+	}
+	logrus.
+		WithField("trades", len(missed)).
+		WithField("since", m.lastTradeID).
+		Infof("Recovered missed trades")
+	for i := len(missed) - 1; i >= 0; i-- {
+		t := missed[i]
+		t.Market = Name
+		t.Product = m.product
+		t.ID = fmt.Sprintf("%s.%s.%d", t.Market, t.Product, t.TradeID)
+		m.handleTrade(t)
+	}
+}
+
+// notify sends an update to all update handlers, after it has been applied
+// to our portfolio
+func (m *gdax) notify(upd *market.Update) {
+	m.portfolio.HandleUpdate(upd) // TODO Handle error
+	// TODO move to channels
+	for _, h := range m.updateHandlers {
+		if h != nil {
+			h.HandleUpdate(upd) // TODO Handle error
+		}
 	}
 }
 
@@ -216,92 +663,305 @@ func (m *gdax) RegisterForUpdates(handler market.UpdateHandler) {
 
 // Buy -
 func (m *gdax) Buy(size, price float64) error {
-	order := &exchange.Order{
-		Price:       price,
-		Size:        size,
-		Side:        "buy",
-		ProductId:   m.product,
-		PostOnly:    true, // TODO Maker
-		TimeInForce: "GTT",
-		CancelAfter: "min",
-		ClientOID:   m.clientOID,
-	}
-	nord, err := m.client.CreateOrder(order)
+	_, err := m.PlaceOrder(market.NewPostOnlyRequest(market.Buy, size, price))
+	return err
+}
+
+// Sell -
+func (m *gdax) Sell(size, price float64) error {
+	_, err := m.PlaceOrder(market.NewPostOnlyRequest(market.Sell, size, price))
+	return err
+}
+
+// orderParams are the params for placing an order, the exchange's order
+// doesn't know about stops
+type orderParams struct {
+	Type        string  `json:"type"`
+	Side        string  `json:"side"`
+	ProductID   string  `json:"product_id"`
+	ClientOID   string  `json:"client_oid,omitempty"`
+	Size        float64 `json:"size,string"`
+	Price       float64 `json:"price,string,omitempty"`
+	TimeInForce string  `json:"time_in_force,omitempty"`
+	CancelAfter string  `json:"cancel_after,omitempty"`
+	PostOnly    bool    `json:"post_only,omitempty"`
+	Stop        string  `json:"stop,omitempty"`
+	StopPrice   float64 `json:"stop_price,string,omitempty"`
+}
+
+// newOrderParams converts a valid request to gdax's params, stop orders are
+// market or limit orders with a stop, buys trigger when the price rises to
+// the stop price and sells when it falls to it
+func (m *gdax) newOrderParams(req market.OrderRequest) *orderParams {
+	params := &orderParams{
+		Type:        string(req.Type),
+		Side:        strings.ToLower(string(req.Side)),
+		ProductID:   m.product,
+		ClientOID:   req.ClientOrderID,
+		Size:        req.Size,
+		Price:       req.Price,
+		TimeInForce: string(req.TimeInForce),
+		CancelAfter: req.CancelAfter,
+		PostOnly:    req.PostOnly,
+	}
+	switch req.Type {
+	case market.StopOrder:
+		params.Type = string(market.MarketOrder)
+	case market.StopLimitOrder:
+		params.Type = string(market.LimitOrder)
+	}
+	if req.Type == market.StopOrder || req.Type == market.StopLimitOrder {
+		params.Stop = "loss"
+		if req.Side == market.Buy {
+			params.Stop = "entry"
+		}
+		params.StopPrice = req.StopPrice
+	}
+	return params
+}
+
+// PlaceOrder places an order with a unique client order id, so that the feed
+// messages gdax sends about it before answering can be buffered and applied
+// once we know the order
+func (m *gdax) PlaceOrder(req market.OrderRequest) (*market.Order, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if req.ClientOrderID == "" {
+		req.ClientOrderID = uuid.New().String()
+	}
+	m.openOrdersLock.Lock()
+	m.placing[req.ClientOrderID] = []*Message{}
+	m.openOrdersLock.Unlock()
+	nord := exchange.Order{}
+	err := m.client.Request(true, http.MethodPost, "/orders", m.newOrderParams(req), &nord)
 	if err != nil {
-		return err
+		m.openOrdersLock.Lock()
+		m.stopPlacing(req.ClientOrderID)
+		m.openOrdersLock.Unlock()
+		return nil, err
 	}
 	if nord.Status == "rejected" {
-		return ErrorOrderRejected
+		// gdax got the order but did not take it, eg. a post only order
+		// that would have crossed the book
+		ord := market.NewOrder(nord.Id, req, nord.CreatedAt.Time())
+		ord.Reject() // TODO Handle error
+		m.openOrdersLock.Lock()
+		m.stopPlacing(req.ClientOrderID)
+		m.openOrdersLock.Unlock()
+		m.notify(ord.Update(market.Cancel, req.Price, req.Size, time.Now().UTC()))
+		return nil, ErrorOrderRejected
+	}
+	ord := market.NewOrder(nord.Id, req, nord.CreatedAt.Time())
+	if nord.Status == "open" {
+		ord.Open() // TODO Handle error
 	}
-	// add order to orders
 	m.openOrdersLock.Lock()
-	defer m.openOrdersLock.Unlock()
-	m.openOrders[nord.Id] = &nord
+	upd := ord.Update(market.Open, req.Price, req.Size, time.Now().UTC())
+	m.openOrdersLock.Unlock()
 	// report event
 	logrus.
-		WithField("price", utils.TrimFloat64(order.Price, 2)).
-		WithField("size", utils.TrimFloat64(order.Size, 8)).
-		Infof("Placed buy order")
-	m.balanceCacheValid = false // TODO Remove balance cache
-	return nil
+		WithField("type", req.Type).
+		WithField("price", utils.TrimFloat64(req.Price, 2)).
+		WithField("size", utils.TrimFloat64(req.Size, 8)).
+		Infof("Placed %s order", strings.ToLower(string(req.Side)))
+	m.notify(upd)
+	// apply what the feed told us so far, messages that come while we are
+	// doing so are buffered too so that they are applied in order
+	gone := false
+	for {
+		m.openOrdersLock.Lock()
+		buffered := m.placing[req.ClientOrderID]
+		if len(buffered) == 0 {
+			m.stopPlacing(req.ClientOrderID)
+			if !gone && !ord.Done() {
+				m.openOrders[ord.ID] = ord
+			}
+			placed := *ord
+			m.openOrdersLock.Unlock()
+			return &placed, nil
+		}
+		m.placing[req.ClientOrderID] = []*Message{}
+		m.openOrdersLock.Unlock()
+		for _, message := range buffered {
+			if m.applyOrderMessage(ord, message) {
+				gone = true
+			}
+		}
+	}
 }
 
-// Sell -
-func (m *gdax) Sell(size, price float64) error {
-	order := &exchange.Order{
-		Price:       price,
-		Size:        size,
-		Side:        "sell",
-		ProductId:   m.product,
-		PostOnly:    true, // TODO Maker
-		TimeInForce: "GTT",
-		CancelAfter: "min",
-		ClientOID:   m.clientOID,
-	}
-	nord, err := m.client.CreateOrder(order)
-	if err != nil {
+// CancelOrder asks gdax to cancel an order, the cancel update is sent once
+// the feed tells us the order is done
+func (m *gdax) CancelOrder(id string) error {
+	m.openOrdersLock.Lock()
+	if _, ok := m.openOrders[id]; ok {
+		m.cancelling[id] = true
+	}
+	m.openOrdersLock.Unlock()
+	if err := m.client.CancelOrder(id); err != nil {
+		m.openOrdersLock.Lock()
+		delete(m.cancelling, id)
+		m.openOrdersLock.Unlock()
+		if rerr, ok := err.(*RequestError); ok && rerr.StatusCode == http.StatusNotFound {
+			return ErrorOrderNotFound
+		}
 		return err
 	}
-	if nord.Status == "rejected" {
-		return ErrorOrderRejected
+	return nil
+}
+
+// AmendOrder cancels one of our open orders and places one with the same
+// terms but the given size and price, gdax can't change orders in place
+func (m *gdax) AmendOrder(id string, size, price float64) (*market.Order, error) {
+	m.openOrdersLock.RLock()
+	ord, ok := m.openOrders[id]
+	var req market.OrderRequest
+	if ok {
+		req = ord.AmendRequest(size, price)
+	}
+	m.openOrdersLock.RUnlock()
+	if !ok {
+		return nil, ErrorOrderNotFound
 	}
-	// add order to orders
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if err := m.CancelOrder(id); err != nil {
+		return nil, err
+	}
+	return m.PlaceOrder(req)
+}
+
+// CancelAll cancels all our open orders for the product
+func (m *gdax) CancelAll() error {
 	m.openOrdersLock.Lock()
-	defer m.openOrdersLock.Unlock()
-	m.openOrders[nord.Id] = &nord
-	// report event
-	logrus.
-		WithField("price", utils.TrimFloat64(order.Price, 2)).
-		WithField("size", utils.TrimFloat64(order.Size, 8)).
-		Infof("Placed buy order")
-	m.balanceCacheValid = false // TODO Remove balance cache
+	for id := range m.openOrders {
+		m.cancelling[id] = true
+	}
+	m.openOrdersLock.Unlock()
+	ids, err := m.client.CancelAll(m.product)
+	if err != nil {
+		return err
+	}
+	logrus.WithField("orders", len(ids)).Infof("Cancelled all orders")
 	return nil
 }
 
-// GetBalance -
-func (m *gdax) GetBalance() (assets float64, currency float64, err error) {
-	if m.balanceCacheValid {
-		return m.balanceCacheAsset, m.balanceCacheCurrency, nil
+// GetOpenOrders returns our open and pending orders for the product
+func (m *gdax) GetOpenOrders() ([]*market.Order, error) {
+	ords, err := m.client.ListOrders(m.product, "open", "pending", "active")
+	if err != nil {
+		return nil, err
 	}
+	orders := []*market.Order{}
+	for _, ord := range ords {
+		orders = append(orders, toOrder(ord))
+	}
+	return orders, nil
+}
+
+// GetOrder -
+func (m *gdax) GetOrder(id string) (*market.Order, error) {
+	ord, err := m.client.GetOrder(id)
+	if err != nil {
+		if rerr, ok := err.(*RequestError); ok && rerr.StatusCode == http.StatusNotFound {
+			return nil, ErrorOrderNotFound
+		}
+		return nil, err
+	}
+	return toOrder(ord), nil
+}
+
+// toOrder converts an exchange order, the exchange's order has no stop price
+// so stop orders come back as market or limit orders
+func toOrder(ord exchange.Order) *market.Order {
+	status := market.OrderStatus(ord.Status)
+	switch {
+	case ord.Status == "active":
+		status = market.OrderPending
+	case ord.Status == "open" && ord.FilledSize > 0:
+		status = market.OrderPartiallyFilled
+	case ord.Status == "done" && ord.DoneReason == "filled":
+		status = market.OrderFilled
+	case ord.Status == "done":
+		status = market.OrderCancelled
+	}
+	avg := 0.0
+	if ord.FilledSize > 0 {
+		avg = ord.ExecutedValue / ord.FilledSize
+	}
+	return &market.Order{
+		ID:               ord.Id,
+		ClientOrderID:    ord.ClientOID,
+		Side:             side(ord.Side),
+		Type:             market.OrderType(ord.Type),
+		Size:             ord.Size,
+		Price:            ord.Price,
+		TimeInForce:      market.TimeInForce(ord.TimeInForce),
+		PostOnly:         ord.PostOnly,
+		Status:           status,
+		FilledSize:       ord.FilledSize,
+		AverageFillPrice: avg,
+		Fees:             ord.FillFees,
+		CreatedAt:        ord.CreatedAt.Time(),
+	}
+}
+
+// Fees returns the fee model we estimate our fills' fees with
+func (m *gdax) Fees() market.FeeModel {
+	return m.fees
+}
+
+// OrderBook returns the level2 book, which is empty until we are connected
+func (m *gdax) OrderBook() market.OrderBook {
+	return m.book
+}
+
+// Account returns our portfolio, which is empty until we have connected
+func (m *gdax) Account() market.Account {
+	return m.portfolio
+}
+
+// GetBalance returns our balances from the portfolio, getting them from gdax
+// if we have not done so yet
+func (m *gdax) GetBalance() (asset market.Balance, currency market.Balance, err error) {
+	if atomic.LoadInt32(&m.reconciled) == 0 {
+		if err := m.reconcileAccount(); err != nil {
+			return market.Balance{}, market.Balance{}, err
+		}
+	}
+	return m.portfolio.Balance(m.asset()), m.portfolio.Balance(m.currency()), nil
+}
+
+// reconcileAccount resets our portfolio from gdax's accounts
+func (m *gdax) reconcileAccount() error {
 	acs, err := m.client.GetAccounts()
 	if err != nil {
-		return 0, 0, err
+		return err
 	}
-	cast := m.asset()
-	ccur := m.currency()
-	ast := 0.0
-	cur := 0.0
+	balances := []market.Balance{}
 	for _, acc := range acs {
-		switch acc.Currency {
-		case cast:
-			ast = utils.TrimFloat64(acc.Available, 6)
-		case ccur:
-			cur = utils.TrimFloat64(acc.Available, 2)
+		balances = append(balances, market.Balance{
+			Currency:  strings.ToUpper(acc.Currency),
+			Available: acc.Available,
+			Hold:      acc.Hold,
+		})
+	}
+	m.portfolio.Set(balances...)
+	atomic.StoreInt32(&m.reconciled, 1)
+	return nil
+}
+
+// reconcileAccounts periodically resets our portfolio from gdax's accounts
+func (m *gdax) reconcileAccounts() {
+	ticker := time.NewTicker(accountReconcileInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := m.reconcileAccount(); err != nil {
+			logrus.WithError(err).Warnf("Could not get accounts")
 		}
 	}
-	m.balanceCacheAsset = ast
-	m.balanceCacheCurrency = cur
-	return ast, cur, nil
 }
 
 // Run -
@@ -313,41 +973,136 @@ func (m *gdax) Run() {
 	// } else {
 	// 	m.profileID=accs[0].
 	// }
-	// new client id
-	m.clientOID = uuid.New().String()
+	go m.reconcileAccounts()
+	// reconnect with backoff, starting over if the connection was up for a
+	// while
+	attempt := 0
 	for {
-		m.Listen()
+		connected := time.Now()
+		err := m.Listen()
+		if time.Since(connected) > wsStableAfter {
+			attempt = 0
+		}
+		attempt++
+		wait := backoff(attempt)
+		logrus.
+			WithError(err).
+			WithField("retry-in", wait).
+			Warnf("gdax ws disconnected")
+		time.Sleep(wait)
 	}
 }
 
-func (m *gdax) Backfill(end time.Time) error {
-	uns := end.Format("2006-01-02 15:04:05")
-	fmt.Printf("Backfilling %s.%s up to %s\n", Name, m.product, uns)
-	// TODO Skip time spans we already have
-	var trades []*market.Trade
+// Backfill walks trades from end back to start, skipping the trade id ranges
+// we already have, so an interrupted backfill picks up where it left off when
+// run again
+func (m *gdax) Backfill(start, end time.Time) error {
+	fmt.Printf(
+		"Backfilling %s.%s from %s to %s\n",
+		Name,
+		m.product,
+		start.Format("2006-01-02 15:04:05"),
+		end.Format("2006-01-02 15:04:05"),
+	)
+	ranges, err := m.persistence.GetTradeRanges(Name, m.product, start, end)
+	if err != nil {
+		fmt.Println("Could not get existing trades", err)
+		return err
+	}
+	latest, err := m.tradeBefore(0)
+	if err != nil {
+		fmt.Println("Could not get latest trade", err)
+		return err
+	}
+	if latest == nil {
+		return errors.New("No trades found")
+	}
+	after := 0
+	// jump to the first trade before end instead of paging from the present
+	if latest.Time.After(end) {
+		after, err = m.searchTradeID(latest, end)
+		if err != nil {
+			fmt.Println("Could not find first trade", err)
+			return err
+		}
+	}
 	total := 0
-	cur := m.client.ListTrades(m.product)
-	for cur.HasMore {
-		if err := cur.NextPage(&trades); err == nil {
-			for _, t := range trades {
-				t.Market = Name
-				t.Product = m.product
-				t.ID = fmt.Sprintf("%s.%s.%d", t.Market, t.Product, t.TradeID)
-			}
-			if err := m.persistence.PutTrade(trades...); err != nil {
-				fmt.Println("Could not put trades", err)
-				return err
-			}
-			total += len(trades)
-			lt := trades[len(trades)-1]
-			if lt.Time.Before(end) {
-				fmt.Printf("Saved %d trades; Done!\n", total)
+	for {
+		trades, err := m.client.ListTrades(m.product, after, tradesPageLimit)
+		if err != nil {
+			fmt.Println("Could not get trades", err)
+			return err
+		}
+		if len(trades) == 0 {
+			fmt.Printf("Saved %d trades, no older trades; Done!\n", total)
+			return nil
+		}
+		for _, t := range trades {
+			t.Market = Name
+			t.Product = m.product
+			t.ID = fmt.Sprintf("%s.%s.%d", t.Market, t.Product, t.TradeID)
+		}
+		if err := m.persistence.PutTrade(trades...); err != nil {
+			fmt.Println("Could not put trades", err)
+			return err
+		}
+		total += len(trades)
+		lt := trades[len(trades)-1]
+		if lt.Time.Before(start) {
+			fmt.Printf("Saved %d trades; Done!\n", total)
+			return nil
+		}
+		after = lt.TradeID
+		// skip over the trades we already have
+		if rng := rangeContaining(ranges, lt.TradeID); rng != nil {
+			if rng.Start.Before(start) {
+				fmt.Printf("Saved %d trades, already have the rest; Done!\n", total)
 				return nil
 			}
-			fmt.Printf("Saved %d trades, %0.2f hours left.\n", total, lt.Time.Sub(end).Hours())
-			time.Sleep(time.Millisecond * 300)
+			fmt.Printf("Skipping %d trades we already have.\n", rng.Count())
+			after = rng.First
+		}
+		fmt.Printf("Saved %d trades, %0.2f hours left.\n", total, lt.Time.Sub(start).Hours())
+	}
+}
+
+// tradeBefore returns the newest trade with an id lower than the given one,
+// or the latest trade if the id is 0, and nil if there is no such trade
+func (m *gdax) tradeBefore(tradeID int) (*market.Trade, error) {
+	trades, err := m.client.ListTrades(m.product, tradeID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(trades) == 0 {
+		return nil, nil
+	}
+	return trades[0], nil
+}
+
+// searchTradeID does a binary search over trade ids for the oldest trade
+// after the given time, starting from a trade that is after it
+func (m *gdax) searchTradeID(newer *market.Trade, at time.Time) (int, error) {
+	lo, hi := 0, newer.TradeID
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		t, err := m.tradeBefore(mid + 1)
+		if err != nil {
+			return 0, err
+		}
+		if t != nil && t.Time.After(at) {
+			hi = mid
 		} else {
-			fmt.Println("Error getting next page: err", err)
+			lo = mid
+		}
+	}
+	return hi, nil
+}
+
+// rangeContaining returns the range that contains the given trade id, or nil
+func rangeContaining(ranges []*persistence.TradeRange, tradeID int) *persistence.TradeRange {
+	for _, r := range ranges {
+		if r.Contains(tradeID) {
+			return r
 		}
 	}
 	return nil
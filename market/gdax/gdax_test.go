@@ -0,0 +1,150 @@
+package gdax
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+// updates keeps the updates it handles
+type updates []*market.Update
+
+func (u *updates) HandleUpdate(upd *market.Update) error {
+	*u = append(*u, upd)
+	return nil
+}
+
+// newTestMarket creates a market whose rest requests go to the handler,
+// without rate limits
+func newTestMarket(t *testing.T, handler http.HandlerFunc) (*gdax, func()) {
+	mrk, err := New(nil, "BTC-USD", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	m := mrk.(*gdax)
+	m.client.client.BaseURL = srv.URL
+	m.client.public = newLimiter(1000, 1000)
+	m.client.private = newLimiter(1000, 1000)
+	return m, srv.Close
+}
+
+func TestPlaceOrderRejected(t *testing.T) {
+	m, done := newTestMarket(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"a","status":"rejected","created_at":"2018-01-02T00:00:00Z"}`)
+	})
+	defer done()
+	got := &updates{}
+	m.RegisterForUpdates(got)
+	ord, err := m.PlaceOrder(market.NewPostOnlyRequest(market.Buy, 1, 100))
+	if err != ErrorOrderRejected || ord != nil {
+		t.Fatalf("expected the order to be rejected, got %v %v", ord, err)
+	}
+	if len(*got) != 1 {
+		t.Fatalf("expected 1 update, got %d", len(*got))
+	}
+	if upd := (*got)[0]; upd.OrderID != "a" || upd.Action != market.Cancel || upd.Status != market.OrderRejected {
+		t.Errorf("expected a rejection of a, got %s %s %s", upd.OrderID, upd.Action, upd.Status)
+	}
+	if len(m.placing) != 0 || len(m.openOrders) != 0 {
+		t.Errorf("expected the order to be forgotten")
+	}
+	if cur := m.portfolio.Balance("USD"); cur.Hold != 0 {
+		t.Errorf("expected nothing to be held, got %v", cur.Hold)
+	}
+}
+
+func TestSearchTradeID(t *testing.T) {
+	start := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	// trade i happened i*10s after start, 5 and 6 are missing
+	at := func(id int) time.Time {
+		return start.Add(time.Duration(id) * 10 * time.Second)
+	}
+	exists := func(id int) bool {
+		return id >= 1 && id <= 20 && id != 5 && id != 6
+	}
+	requests := int32(0)
+	m, done := newTestMarket(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		id := after - 1
+		for id > 0 && !exists(id) {
+			id--
+		}
+		if id <= 0 {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		fmt.Fprintf(w, `[{"trade_id":%d,"price":"100","size":"1","time":"%s","side":"buy"}]`, id, at(id).Format(time.RFC3339))
+	})
+	defer done()
+	tests := []struct {
+		name  string
+		at    time.Time
+		newer int
+		id    int
+	}{
+		{"between trades", start.Add(35 * time.Second), 20, 4},
+		{"at a trade", at(4), 20, 7},
+		{"in a gap", start.Add(55 * time.Second), 20, 7},
+		{"before all trades", start, 20, 1},
+		{"right before the newer trade", at(19), 20, 20},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&requests, 0)
+		id, err := m.searchTradeID(&market.Trade{TradeID: test.newer, Time: at(test.newer)}, test.at)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if id != test.id {
+			t.Errorf("%s: expected trade %d, got %d", test.name, test.id, id)
+		}
+		if n := atomic.LoadInt32(&requests); n > 5 {
+			t.Errorf("%s: expected a binary search, made %d requests", test.name, n)
+		}
+	}
+}
+
+func TestRecordBook(t *testing.T) {
+	m, done := newTestMarket(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer done()
+	m.bookRecords = make(chan *market.BookUpdate, 10)
+	at := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	m.handleMessage(&Message{
+		Type: "snapshot",
+		Bids: [][]string{{"99", "1"}},
+		Asks: [][]string{{"101", "1"}},
+	})
+	if len(m.bookRecords) != 0 {
+		t.Fatalf("expected the snapshot to wait for a time")
+	}
+	if bid, _ := m.book.BestBid(); bid.Price != 99 {
+		t.Errorf("expected the snapshot to be applied, got a bid of %v", bid.Price)
+	}
+	m.handleMessage(&Message{
+		Type:    "l2update",
+		Time:    exchange.Time(at),
+		Changes: [][]string{{"buy", "100", "1"}},
+	})
+	if len(m.bookRecords) != 2 {
+		t.Fatalf("expected 2 recorded updates, got %d", len(m.bookRecords))
+	}
+	snap, change := <-m.bookRecords, <-m.bookRecords
+	if snap.Type != market.BookSnapshot || !snap.Time.Equal(at) {
+		t.Errorf("expected the snapshot to get the change's time, got %s %s", snap.Type, snap.Time)
+	}
+	if change.Type != market.BookChange || !change.Time.Equal(at) {
+		t.Errorf("expected the change, got %s %s", change.Type, change.Time)
+	}
+	if snap.Sequence >= change.Sequence {
+		t.Errorf("expected the snapshot to come first, got sequences %d and %d", snap.Sequence, change.Sequence)
+	}
+}
@@ -27,6 +27,11 @@ type Message struct {
 	OldFunds      float64       `json:"old_funds,string"`
 	Message       string        `json:"message"`
 
+	// level2
+	Bids    [][]string `json:"bids"`
+	Asks    [][]string `json:"asks"`
+	Changes [][]string `json:"changes"`
+
 	// private
 	TakerUserID    string `json:"taker_user_id"`
 	TakerProfileID string `json:"taker_profile_id"`
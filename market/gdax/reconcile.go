@@ -0,0 +1,191 @@
+package gdax
+
+import (
+	"sort"
+	"time"
+
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	logrus "github.com/sirupsen/logrus"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+// reconcileOrders brings our orders in line with gdax when we (re)connect.
+// Open orders we don't know about, eg. because we were restarted, are adopted
+// and the fills we missed while disconnected are published. Orders that got
+// done while we were away are ended, as we will never see their done message.
+func (m *gdax) reconcileOrders() {
+	listed := time.Now()
+	ords, err := m.client.ListOrders(m.product, "open", "pending", "active")
+	if err != nil {
+		logrus.WithError(err).Warnf("Could not get open orders")
+		return
+	}
+	open := map[string]bool{}
+	adopted := 0
+	for _, ex := range ords {
+		open[ex.Id] = true
+		m.openOrdersLock.Lock()
+		ord, ok := m.openOrders[ex.Id]
+		if _, placing := m.placing[ex.ClientOID]; !ok && placing {
+			// PlaceOrder will register it
+			m.openOrdersLock.Unlock()
+			continue
+		}
+		var upd *market.Update
+		if !ok {
+			ord = adoptOrder(ex)
+			m.openOrders[ord.ID] = ord
+			upd = ord.Update(market.Open, ord.Price, ord.Size, ord.CreatedAt)
+			adopted++
+		}
+		m.openOrdersLock.Unlock()
+		if upd != nil {
+			m.notify(upd)
+		}
+		m.catchUpFills(ord)
+	}
+	// orders placed after we listed the open ones are not done
+	m.openOrdersLock.Lock()
+	gone := []*market.Order{}
+	for id, ord := range m.openOrders {
+		if !open[id] && ord.CreatedAt.Before(listed) {
+			gone = append(gone, ord)
+			delete(m.openOrders, id)
+		}
+	}
+	m.openOrdersLock.Unlock()
+	now := time.Now().UTC()
+	ended := 0
+	for _, ord := range gone {
+		if !m.catchUpFills(ord) {
+			// try again next time instead of ending an order that
+			// might have been filled
+			m.openOrdersLock.Lock()
+			m.openOrders[ord.ID] = ord
+			m.openOrdersLock.Unlock()
+			continue
+		}
+		m.endOrder(ord, now, m.forget(ord.ID))
+		ended++
+	}
+	logrus.
+		WithField("open", len(ords)).
+		WithField("adopted", adopted).
+		WithField("done", ended).
+		Infof("Reconciled orders")
+}
+
+// adoptOrder creates the state of an open order placed by someone else, or
+// by us before a restart, its fills are added by catchUpFills
+func adoptOrder(ex exchange.Order) *market.Order {
+	req := market.OrderRequest{
+		Side:          side(ex.Side),
+		Type:          market.OrderType(ex.Type),
+		Size:          ex.Size,
+		Price:         ex.Price,
+		TimeInForce:   market.TimeInForce(ex.TimeInForce),
+		PostOnly:      ex.PostOnly,
+		ClientOrderID: ex.ClientOID,
+	}
+	ord := market.NewOrder(ex.Id, req, ex.CreatedAt.Time())
+	if ex.Status == "open" {
+		ord.Open() // TODO Handle error
+	}
+	return ord
+}
+
+// catchUpFills gets an order's fills over rest and publishes the ones we
+// have not seen, oldest first. The fees we estimated for the fills we have
+// seen are corrected to what gdax actually charged, with fills of no size
+// that carry the difference. Returns false if the fills could not be fetched.
+func (m *gdax) catchUpFills(ord *market.Order) bool {
+	fills, err := m.client.ListFills(ord.ID)
+	if err != nil {
+		logrus.WithError(err).WithField("order", ord.ID).Warnf("Could not get order fills")
+		return false
+	}
+	sort.Slice(fills, func(i, j int) bool {
+		return fills[i].TradeID < fills[j].TradeID
+	})
+	upds := []*market.Update{}
+	m.openOrdersLock.Lock()
+	for _, f := range fills {
+		if est, seen := m.fills[ord.ID][f.TradeID]; seen {
+			if f.Fee != est {
+				ord.Fees += f.Fee - est
+				m.seeFill(ord.ID, f.TradeID, f.Fee)
+				upd := ord.Update(ord.Side, f.Price, 0, f.CreatedAt.Time())
+				upd.Fee = f.Fee - est
+				upd.Liquidity = market.Liquidity(f.Liquidity)
+				upds = append(upds, upd)
+			}
+			continue
+		}
+		if err := ord.Fill(f.Size, f.Price, f.Fee); err != nil {
+			logrus.WithError(err).WithField("order", ord.ID).Warnf("Could not fill order")
+			break
+		}
+		m.seeFill(ord.ID, f.TradeID, f.Fee)
+		upd := ord.Update(ord.Side, f.Price, f.Size, f.CreatedAt.Time())
+		upd.Fee = f.Fee
+		upd.Liquidity = market.Liquidity(f.Liquidity)
+		upds = append(upds, upd)
+	}
+	m.openOrdersLock.Unlock()
+	if len(upds) > 0 {
+		logrus.
+			WithField("order", ord.ID).
+			WithField("fills", len(upds)).
+			Infof("Caught up with missed fills")
+	}
+	for _, upd := range upds {
+		m.notify(upd)
+	}
+	return true
+}
+
+// seeFill remembers a fill and its fee, must be called while holding the
+// orders lock
+func (m *gdax) seeFill(orderID string, tradeID int, fee float64) {
+	if m.fills[orderID] == nil {
+		m.fills[orderID] = map[int]float64{}
+	}
+	m.fills[orderID][tradeID] = fee
+}
+
+// forget drops what we know about the fills and cancellation of an order
+// that has been removed from the open orders, returns true if we asked for it
+// to be cancelled
+func (m *gdax) forget(orderID string) bool {
+	m.openOrdersLock.Lock()
+	defer m.openOrdersLock.Unlock()
+	cancelled := m.cancelling[orderID]
+	delete(m.cancelling, orderID)
+	delete(m.fills, orderID)
+	return cancelled
+}
+
+// endOrder ends an order that gdax is done with but did not fill. Orders that
+// are not filled by now have been cancelled, or expired if we did not cancel
+// them ourselves and they have a time in force. The order is shared with
+// PlaceOrder and the feed, so it only changes while holding the orders lock.
+func (m *gdax) endOrder(ord *market.Order, at time.Time, cancelled bool) {
+	m.openOrdersLock.Lock()
+	if ord.Done() {
+		m.openOrdersLock.Unlock()
+		return
+	}
+	end := ord.Cancel
+	if !cancelled && ord.TimeInForce == market.GoodTillTime {
+		end = ord.Expire
+	}
+	if err := end(); err != nil {
+		m.openOrdersLock.Unlock()
+		logrus.WithError(err).WithField("order", ord.ID).Warnf("Could not end order")
+		return
+	}
+	upd := ord.Update(market.Cancel, ord.Price, ord.Remaining(), at)
+	m.openOrdersLock.Unlock()
+	m.notify(upd)
+}
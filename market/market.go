@@ -8,9 +8,23 @@ import (
 type Market interface {
 	RegisterForTrades(handler TradeHandler)
 	RegisterForUpdates(handler UpdateHandler)
-	GetBalance() (assets float64, currency float64, err error)
+	// GetBalance returns our balances of the product's asset and currency,
+	// only what is available can be used for new orders
+	GetBalance() (asset Balance, currency Balance, err error)
+	Account() Account
+	// Fees returns the fees the market charges, or estimates them with
+	Fees() FeeModel
+	OrderBook() OrderBook
 	Buy(quantity, price float64) error
 	Sell(quantity, price float64) error
+	PlaceOrder(req OrderRequest) (*Order, error)
+	CancelOrder(id string) error
+	// AmendOrder replaces an open order with one of the given size and
+	// price, the order is cancelled and the new one placed in its place
+	AmendOrder(id string, size, price float64) (*Order, error)
+	CancelAll() error
+	GetOpenOrders() ([]*Order, error)
+	GetOrder(id string) (*Order, error)
 	Run()
-	Backfill(end time.Time) error
+	Backfill(start, end time.Time) error
 }
@@ -0,0 +1,328 @@
+package market
+
+import (
+	"errors"
+	"time"
+)
+
+// OrderType -
+type OrderType string
+
+const (
+	// MarketOrder is filled right away at whatever price the market offers
+	MarketOrder OrderType = "market"
+	// LimitOrder is only filled at its price or better
+	LimitOrder OrderType = "limit"
+	// StopOrder becomes a market order once the market trades through its
+	// stop price
+	StopOrder OrderType = "stop"
+	// StopLimitOrder becomes a limit order once the market trades through
+	// its stop price
+	StopLimitOrder OrderType = "stop_limit"
+)
+
+// TimeInForce -
+type TimeInForce string
+
+const (
+	// GoodTillCanceled orders stay open until they are filled or cancelled
+	GoodTillCanceled TimeInForce = "GTC"
+	// GoodTillTime orders get cancelled after CancelAfter
+	GoodTillTime TimeInForce = "GTT"
+	// ImmediateOrCancel orders fill what they can and cancel the rest
+	ImmediateOrCancel TimeInForce = "IOC"
+	// FillOrKill orders are either filled completely or cancelled
+	FillOrKill TimeInForce = "FOK"
+)
+
+// OrderStatus -
+type OrderStatus string
+
+const (
+	// OrderPending orders have not reached the book yet, or are stop orders
+	// that have not been triggered
+	OrderPending OrderStatus = "pending"
+	// OrderOpen orders are resting in the book
+	OrderOpen OrderStatus = "open"
+	// OrderPartiallyFilled orders have been filled in part, the rest is
+	// still open
+	OrderPartiallyFilled OrderStatus = "partially_filled"
+	// OrderFilled orders have been filled completely
+	OrderFilled OrderStatus = "filled"
+	// OrderCancelled orders have been cancelled before being filled
+	// completely
+	OrderCancelled OrderStatus = "cancelled"
+	// OrderRejected orders never made it to the book
+	OrderRejected OrderStatus = "rejected"
+	// OrderExpired orders ran out of time before being filled completely
+	OrderExpired OrderStatus = "expired"
+)
+
+const (
+	// orderDust is the smallest size we consider left to fill
+	orderDust = 1e-8
+)
+
+var (
+	ErrorInvalidTransition = errors.New("Invalid order status transition")
+)
+
+// orderTransitions lists the statuses each status can move to, filled,
+// cancelled, rejected, and expired orders are done and can't move anymore
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending: []OrderStatus{
+		OrderOpen,
+		OrderPartiallyFilled,
+		OrderFilled,
+		OrderCancelled,
+		OrderRejected,
+		OrderExpired,
+	},
+	OrderOpen: []OrderStatus{
+		OrderPartiallyFilled,
+		OrderFilled,
+		OrderCancelled,
+		OrderExpired,
+	},
+	OrderPartiallyFilled: []OrderStatus{
+		OrderPartiallyFilled,
+		OrderFilled,
+		OrderCancelled,
+		OrderExpired,
+	},
+}
+
+// Done checks if orders with this status can't change anymore
+func (s OrderStatus) Done() bool {
+	return len(orderTransitions[s]) == 0
+}
+
+// CanMoveTo checks if an order can go from this status to the given one
+func (s OrderStatus) CanMoveTo(to OrderStatus) bool {
+	for _, t := range orderTransitions[s] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderRequest describes an order to be placed
+type OrderRequest struct {
+	Side Action
+	// Type defaults to LimitOrder
+	Type OrderType
+	Size float64
+	// Price is the limit price of limit and stop limit orders
+	Price float64
+	// StopPrice triggers stop and stop limit orders
+	StopPrice float64
+	// TimeInForce of limit orders, defaults to GoodTillCanceled
+	TimeInForce TimeInForce
+	// CancelAfter is one of min, hour, or day for GoodTillTime orders
+	CancelAfter string
+	// PostOnly limit orders are rejected instead of taking liquidity
+	PostOnly bool
+	// ClientOrderID is an optional id of our own for the order
+	ClientOrderID string
+}
+
+// Validate checks the request and fills in the defaults
+func (r *OrderRequest) Validate() error {
+	if r.Side != Buy && r.Side != Sell {
+		return errors.New("Order side needs to be buy or sell")
+	}
+	if r.Size <= 0 {
+		return errors.New("Order size needs to be positive")
+	}
+	if r.Type == "" {
+		r.Type = LimitOrder
+	}
+	switch r.Type {
+	case MarketOrder, StopOrder:
+		if r.TimeInForce != "" {
+			return errors.New("Only limit orders can have a time in force")
+		}
+		if r.PostOnly {
+			return errors.New("Only limit orders can be post only")
+		}
+	case LimitOrder, StopLimitOrder:
+		if r.Price <= 0 {
+			return errors.New("Limit orders need a positive price")
+		}
+		if r.TimeInForce == "" {
+			r.TimeInForce = GoodTillCanceled
+		}
+	default:
+		return errors.New("Unknown order type " + string(r.Type))
+	}
+	if r.Type == StopOrder || r.Type == StopLimitOrder {
+		if r.StopPrice <= 0 {
+			return errors.New("Stop orders need a positive stop price")
+		}
+	}
+	switch r.TimeInForce {
+	case "", GoodTillCanceled:
+	case GoodTillTime:
+		if r.CancelAfter != "min" && r.CancelAfter != "hour" && r.CancelAfter != "day" {
+			return errors.New("Cancel after needs to be min, hour, or day")
+		}
+	case ImmediateOrCancel, FillOrKill:
+		if r.PostOnly {
+			return errors.New("Immediate orders can not be post only")
+		}
+	default:
+		return errors.New("Unknown time in force " + string(r.TimeInForce))
+	}
+	return nil
+}
+
+// Order is a placed order, its status should only be changed through Open,
+// Fill, Cancel, Reject, and Expire so that it follows the allowed transitions
+type Order struct {
+	ID            string
+	ClientOrderID string
+	Side          Action
+	Type          OrderType
+	Size          float64
+	Price         float64
+	StopPrice     float64
+	TimeInForce   TimeInForce
+	// CancelAfter of GoodTillTime orders, as requested
+	CancelAfter string
+	PostOnly    bool
+	Status      OrderStatus
+	FilledSize  float64
+	// AverageFillPrice is the size weighted price of the fills
+	AverageFillPrice float64
+	// Fees paid in quote currency for the fills
+	Fees      float64
+	CreatedAt time.Time
+}
+
+// NewOrder creates a pending order from a valid request
+func NewOrder(id string, req OrderRequest, at time.Time) *Order {
+	return &Order{
+		ID:            id,
+		ClientOrderID: req.ClientOrderID,
+		Side:          req.Side,
+		Type:          req.Type,
+		Size:          req.Size,
+		Price:         req.Price,
+		StopPrice:     req.StopPrice,
+		TimeInForce:   req.TimeInForce,
+		CancelAfter:   req.CancelAfter,
+		PostOnly:      req.PostOnly,
+		Status:        OrderPending,
+		CreatedAt:     at,
+	}
+}
+
+// AmendRequest returns the request for an order with the same terms as this
+// one but the given size and price, which replaces it when amending
+func (o *Order) AmendRequest(size, price float64) OrderRequest {
+	return OrderRequest{
+		Side:        o.Side,
+		Type:        o.Type,
+		Size:        size,
+		Price:       price,
+		StopPrice:   o.StopPrice,
+		TimeInForce: o.TimeInForce,
+		CancelAfter: o.CancelAfter,
+		PostOnly:    o.PostOnly,
+	}
+}
+
+// Remaining returns the size that has not been filled yet
+func (o *Order) Remaining() float64 {
+	return o.Size - o.FilledSize
+}
+
+// Done checks if the order can't change anymore
+func (o *Order) Done() bool {
+	return o.Status.Done()
+}
+
+// Open moves a pending order to the book, orders that have already been
+// (partially) filled stay as they are
+func (o *Order) Open() error {
+	if o.Status != OrderPending {
+		if o.Status == OrderPartiallyFilled || o.Status == OrderFilled {
+			return nil
+		}
+		return ErrorInvalidTransition
+	}
+	return o.moveTo(OrderOpen)
+}
+
+// Fill adds a fill to the order, moving it to partially filled or filled
+func (o *Order) Fill(size, price, fee float64) error {
+	status := OrderPartiallyFilled
+	if o.Remaining()-size < orderDust {
+		status = OrderFilled
+	}
+	if err := o.moveTo(status); err != nil {
+		return err
+	}
+	filled := o.FilledSize + size
+	if filled > 0 {
+		o.AverageFillPrice = (o.AverageFillPrice*o.FilledSize + price*size) / filled
+	}
+	o.FilledSize = filled
+	o.Fees += fee
+	return nil
+}
+
+// Cancel -
+func (o *Order) Cancel() error {
+	return o.moveTo(OrderCancelled)
+}
+
+// Reject -
+func (o *Order) Reject() error {
+	return o.moveTo(OrderRejected)
+}
+
+// Expire -
+func (o *Order) Expire() error {
+	return o.moveTo(OrderExpired)
+}
+
+func (o *Order) moveTo(status OrderStatus) error {
+	if !o.Status.CanMoveTo(status) {
+		return ErrorInvalidTransition
+	}
+	o.Status = status
+	return nil
+}
+
+// Update creates an update for the order's last transition, with a copy of
+// the order as it is now. Fills should pass the size and price of the fill,
+// other transitions the order's price and remaining size.
+func (o *Order) Update(action Action, price, size float64, at time.Time) *Update {
+	snapshot := *o
+	return &Update{
+		Action:  action,
+		OrderID: o.ID,
+		Side:    o.Side,
+		Status:  o.Status,
+		Order:   &snapshot,
+		Price:   price,
+		Size:    size,
+		Time:    at,
+	}
+}
+
+// NewPostOnlyRequest creates the request that markets place for Buy and Sell,
+// a post only limit order that gets cancelled after a minute
+func NewPostOnlyRequest(side Action, size, price float64) OrderRequest {
+	return OrderRequest{
+		Side:        side,
+		Type:        LimitOrder,
+		Size:        size,
+		Price:       price,
+		TimeInForce: GoodTillTime,
+		CancelAfter: "min",
+		PostOnly:    true,
+	}
+}
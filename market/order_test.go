@@ -0,0 +1,140 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrderTransitions(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  func(o *Order) error
+		status OrderStatus
+		filled float64
+		fail   bool
+	}{
+		{
+			name:   "open",
+			steps:  (*Order).Open,
+			status: OrderOpen,
+		},
+		{
+			name: "partially filled",
+			steps: func(o *Order) error {
+				return o.Fill(0.4, 100, 0.1)
+			},
+			status: OrderPartiallyFilled,
+			filled: 0.4,
+		},
+		{
+			name: "filled in parts",
+			steps: func(o *Order) error {
+				if err := o.Open(); err != nil {
+					return err
+				}
+				if err := o.Fill(0.4, 100, 0.1); err != nil {
+					return err
+				}
+				return o.Fill(0.6, 100, 0.1)
+			},
+			status: OrderFilled,
+			filled: 1,
+		},
+		{
+			name: "open after a fill keeps the fill",
+			steps: func(o *Order) error {
+				if err := o.Fill(0.4, 100, 0.1); err != nil {
+					return err
+				}
+				return o.Open()
+			},
+			status: OrderPartiallyFilled,
+			filled: 0.4,
+		},
+		{
+			name: "cancel partially filled",
+			steps: func(o *Order) error {
+				if err := o.Fill(0.4, 100, 0.1); err != nil {
+					return err
+				}
+				return o.Cancel()
+			},
+			status: OrderCancelled,
+			filled: 0.4,
+		},
+		{
+			name:   "reject pending",
+			steps:  (*Order).Reject,
+			status: OrderRejected,
+		},
+		{
+			name: "reject open",
+			steps: func(o *Order) error {
+				if err := o.Open(); err != nil {
+					return err
+				}
+				return o.Reject()
+			},
+			status: OrderOpen,
+			fail:   true,
+		},
+		{
+			name: "fill cancelled",
+			steps: func(o *Order) error {
+				if err := o.Cancel(); err != nil {
+					return err
+				}
+				return o.Fill(1, 100, 0.1)
+			},
+			status: OrderCancelled,
+			fail:   true,
+		},
+		{
+			name: "expire filled",
+			steps: func(o *Order) error {
+				if err := o.Fill(1, 100, 0.1); err != nil {
+					return err
+				}
+				return o.Expire()
+			},
+			status: OrderFilled,
+			filled: 1,
+			fail:   true,
+		},
+	}
+	for _, test := range tests {
+		o := NewOrder("1", NewPostOnlyRequest(Buy, 1, 100), time.Time{})
+		err := test.steps(o)
+		if test.fail && err != ErrorInvalidTransition {
+			t.Errorf("%s: expected an invalid transition, got %v", test.name, err)
+		}
+		if !test.fail && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if o.Status != test.status {
+			t.Errorf("%s: expected status %s, got %s", test.name, test.status, o.Status)
+		}
+		if o.FilledSize != test.filled {
+			t.Errorf("%s: expected filled size %v, got %v", test.name, test.filled, o.FilledSize)
+		}
+	}
+}
+
+func TestOrderFillAverages(t *testing.T) {
+	o := NewOrder("1", NewPostOnlyRequest(Sell, 2, 100), time.Time{})
+	if err := o.Fill(1, 100, 0.1); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Fill(1, 110, 0.2); err != nil {
+		t.Fatal(err)
+	}
+	if o.AverageFillPrice != 105 {
+		t.Errorf("expected an average fill price of 105, got %v", o.AverageFillPrice)
+	}
+	if o.Fees < 0.3-1e-9 || o.Fees > 0.3+1e-9 {
+		t.Errorf("expected fees of 0.3, got %v", o.Fees)
+	}
+	if !o.Done() {
+		t.Errorf("expected order to be done")
+	}
+}
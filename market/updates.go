@@ -2,10 +2,28 @@ package market
 
 import "time"
 
-// Update -
+// Update is sent every time one of our orders changes status, including
+// every fill of partially filled orders
 type Update struct {
-	Action Action
-	Price  float64
-	Size   float64
-	Time   time.Time
+	// Action is Open when an order is placed, Activate when a placed order
+	// reaches the book or its stop gets triggered, Buy or Sell when it gets
+	// (partially) filled, and Cancel when it gets cancelled, rejected, or
+	// expires
+	Action  Action
+	OrderID string
+	// Side of the order, Buy or Sell
+	Side Action
+	// Status the order moved to
+	Status OrderStatus
+	// Order as it was right after the update, with its cumulative filled
+	// size, average fill price, and fees
+	Order *Order
+	// Price and Size of the fill for fills, of the order otherwise
+	Price float64
+	Size  float64
+	Time  time.Time
+	// Fee paid in quote currency for fills. Fills with no size correct the
+	// fee of an earlier fill, when markets only estimated it, by Fee.
+	Fee       float64
+	Liquidity Liquidity
 }
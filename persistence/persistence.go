@@ -1,6 +1,8 @@
 package persistence
 
 import (
+	"context"
+	"sort"
 	"time"
 
 	market "github.com/geoah/go-trade/market"
@@ -10,4 +12,95 @@ import (
 type Persistence interface {
 	PutTrade(trades ...*market.Trade) error
 	GetTrades(mrk, prd string, start, end time.Time) ([]*market.Trade, error)
+	// StreamTrades sends the trades between start and end, sorted by time and
+	// trade id, without loading all of them in memory.
+	// The trades channel is closed when done, after which the error channel
+	// yields a single error or nil.
+	StreamTrades(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.Trade, <-chan error)
+	// GetTradeRanges returns the runs of consecutive trade ids we have for
+	// the given market and product between start and end, sorted by trade id
+	GetTradeRanges(mrk, prd string, start, end time.Time) ([]*TradeRange, error)
+	PutBookUpdate(updates ...*market.BookUpdate) error
+	// GetBookSnapshot returns the latest book snapshot at or before the given
+	// time, or nil if there is none
+	GetBookSnapshot(mrk, prd string, at time.Time) (*market.BookUpdate, error)
+	// StreamBookUpdates works like StreamTrades, updates are sorted by time,
+	// then sequence, and then the order they were put in
+	StreamBookUpdates(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.BookUpdate, <-chan error)
+}
+
+const (
+	streamBuffer = 1000
+)
+
+// newStream creates the channels for a stream and runs fn in the background,
+// fn should use the given send function and stop as soon as it returns false
+func newStream(ctx context.Context, fn func(send func(*market.Trade) bool) error) (<-chan *market.Trade, <-chan error) {
+	trades := make(chan *market.Trade, streamBuffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		send := func(t *market.Trade) bool {
+			select {
+			case trades <- t:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		err := fn(send)
+		if err == nil {
+			err = ctx.Err()
+		}
+		close(trades)
+		errs <- err
+	}()
+	return trades, errs
+}
+
+// newBookStream works like newStream for book updates
+func newBookStream(ctx context.Context, fn func(send func(*market.BookUpdate) bool) error) (<-chan *market.BookUpdate, <-chan error) {
+	updates := make(chan *market.BookUpdate, streamBuffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		send := func(u *market.BookUpdate) bool {
+			select {
+			case updates <- u:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		err := fn(send)
+		if err == nil {
+			err = ctx.Err()
+		}
+		close(updates)
+		errs <- err
+	}()
+	return updates, errs
+}
+
+// sortTrades sorts trades by time and then trade id
+func sortTrades(trades []*market.Trade) {
+	sort.Slice(trades, func(i, j int) bool {
+		return tradeBefore(trades[i], trades[j])
+	})
+}
+
+// tradeBefore orders trades by time and then trade id
+func tradeBefore(a, b *market.Trade) bool {
+	if a.Time.Equal(b.Time) {
+		return a.TradeID < b.TradeID
+	}
+	return a.Time.Before(b.Time)
+}
+
+// bookUpdateBefore orders book updates by time and then sequence
+func bookUpdateBefore(a, b *market.BookUpdate) bool {
+	if a.Time.Equal(b.Time) {
+		return a.Sequence < b.Sequence
+	}
+	return a.Time.Before(b.Time)
 }
@@ -0,0 +1,518 @@
+package persistence
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	archiveDayFormat = "2006-01-02"
+	archiveExtension = ".csv.gz"
+	// archiveBookDir holds the book updates of a product
+	archiveBookDir = "book"
+	// archiveCompactMin is how many bytes need to be appended to a file
+	// before it gets compacted, or the file's compacted size if larger
+	archiveCompactMin = 1 << 20
+)
+
+// NewArchive creates a persistence that stores trades in gzipped csv files
+// under the given directory, partitioned by market, product, and day, eg.
+// dir/gdax/BTC-USD/2018-01-02.csv.gz
+// Book updates are stored the same way under dir/gdax/BTC-USD/book/, with
+// their levels as json.
+// Every put appends a new gzip member to the day's file so that backfills
+// don't need to rewrite whole days, duplicates are removed when reading.
+// Files are compacted into a single member once puts move on to another
+// file, or once as much has been appended to them as they held before, so
+// that they compress well and read fast.
+func NewArchive(dir string) (Persistence, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &archive{
+		dir:      dir,
+		appended: map[string]int64{},
+	}, nil
+}
+
+type archive struct {
+	sync.RWMutex
+	dir string
+	// last is the file we last appended to
+	last string
+	// appended holds the bytes appended to files since they were compacted
+	appended map[string]int64
+}
+
+func (p *archive) path(mrk, prd string, day time.Time) string {
+	return filepath.Join(p.dir, mrk, prd, day.UTC().Format(archiveDayFormat)+archiveExtension)
+}
+
+func (p *archive) bookPath(mrk, prd string, day time.Time) string {
+	return filepath.Join(p.dir, mrk, prd, archiveBookDir, day.UTC().Format(archiveDayFormat)+archiveExtension)
+}
+
+func (p *archive) PutTrade(trades ...*market.Trade) error {
+	p.Lock()
+	defer p.Unlock()
+	// group trades by file
+	files := map[string][]*market.Trade{}
+	for _, t := range trades {
+		fp := p.path(t.Market, t.Product, t.Time)
+		files[fp] = append(files[fp], t)
+	}
+	for fp, trades := range files {
+		if err := p.append(fp, trades); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *archive) append(fp string, trades []*market.Trade) error {
+	rows := [][]string{}
+	for _, t := range trades {
+		rows = append(rows, []string{
+			t.ID,
+			strconv.Itoa(t.TradeID),
+			t.Time.UTC().Format(time.RFC3339Nano),
+			strconv.FormatFloat(t.Price, 'f', -1, 64),
+			strconv.FormatFloat(t.Size, 'f', -1, 64),
+			t.Side,
+		})
+	}
+	return p.appendRows(fp, rows)
+}
+
+// appendRows writes the rows as a new gzip member at the end of the file,
+// must be called while holding the lock
+func (p *archive) appendRows(fp string, rows [][]string) error {
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fp, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := writeRows(f, rows); err != nil {
+		return err
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	p.appended[fp] += end - size
+	// puts have moved on from the last file
+	if p.last != "" && p.last != fp {
+		if err := p.compact(p.last); err != nil {
+			return err
+		}
+	}
+	p.last = fp
+	if p.appended[fp] > archiveCompactMin && p.appended[fp] > size {
+		return p.compact(fp)
+	}
+	return nil
+}
+
+// writeRows writes the rows as a single gzip member
+func writeRows(w io.Writer, rows [][]string) error {
+	gw := gzip.NewWriter(w)
+	cw := csv.NewWriter(gw)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// compact rewrites a file as a single gzip member, trades are deduplicated
+// and sorted while book updates keep the order they were written in. Must be
+// called while holding the lock.
+func (p *archive) compact(fp string) error {
+	if p.appended[fp] == 0 {
+		return nil
+	}
+	rows, err := readRows(fp)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(filepath.Dir(fp)) != archiveBookDir {
+		if rows, err = compactTradeRows(rows); err != nil {
+			return err
+		}
+	}
+	tmp := fp + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := writeRows(f, rows); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fp); err != nil {
+		return err
+	}
+	delete(p.appended, fp)
+	return nil
+}
+
+// readRows returns the raw rows of all the members of a file
+func readRows(fp string) ([][]string, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	cr := csv.NewReader(gr)
+	cr.FieldsPerRecord = -1
+	return cr.ReadAll()
+}
+
+// compactTradeRows keeps the last row of every trade id, sorted by time and
+// trade id
+func compactTradeRows(rows [][]string) ([][]string, error) {
+	byID := map[string]int{}
+	trades := []*market.Trade{}
+	kept := [][]string{}
+	for _, rec := range rows {
+		t, err := parseArchiveRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := byID[t.ID]; ok {
+			trades[i] = t
+			kept[i] = rec
+			continue
+		}
+		byID[t.ID] = len(trades)
+		trades = append(trades, t)
+		kept = append(kept, rec)
+	}
+	idx := make([]int, len(trades))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return tradeBefore(trades[idx[i]], trades[idx[j]])
+	})
+	sorted := make([][]string, 0, len(kept))
+	for _, i := range idx {
+		sorted = append(sorted, kept[i])
+	}
+	return sorted, nil
+}
+
+func (p *archive) GetTrades(mrk, prd string, start, end time.Time) ([]*market.Trade, error) {
+	p.RLock()
+	defer p.RUnlock()
+	trades := map[string]*market.Trade{}
+	day := start.UTC().Truncate(24 * time.Hour)
+	for !day.After(end) {
+		if err := p.read(p.path(mrk, prd, day), mrk, prd, start, end, trades); err != nil {
+			return nil, err
+		}
+		day = day.Add(24 * time.Hour)
+	}
+	sorted := make([]*market.Trade, 0, len(trades))
+	for _, t := range trades {
+		sorted = append(sorted, t)
+	}
+	sortTrades(sorted)
+	return sorted, nil
+}
+
+// StreamTrades reads one day's file at a time, as trades are partitioned by
+// their time every day's trades come after the previous day's
+func (p *archive) StreamTrades(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.Trade, <-chan error) {
+	return newStream(ctx, func(send func(*market.Trade) bool) error {
+		day := start.UTC().Truncate(24 * time.Hour)
+		for !day.After(end) {
+			trades := map[string]*market.Trade{}
+			p.RLock()
+			err := p.read(p.path(mrk, prd, day), mrk, prd, start, end, trades)
+			p.RUnlock()
+			if err != nil {
+				return err
+			}
+			sorted := make([]*market.Trade, 0, len(trades))
+			for _, t := range trades {
+				sorted = append(sorted, t)
+			}
+			sortTrades(sorted)
+			for _, t := range sorted {
+				if !send(t) {
+					return nil
+				}
+			}
+			day = day.Add(24 * time.Hour)
+		}
+		return nil
+	})
+}
+
+// GetTradeRanges needs to read every day's file between start and end
+func (p *archive) GetTradeRanges(mrk, prd string, start, end time.Time) ([]*TradeRange, error) {
+	p.RLock()
+	defer p.RUnlock()
+	trades := map[string]*market.Trade{}
+	day := start.UTC().Truncate(24 * time.Hour)
+	for !day.After(end) {
+		if err := p.read(p.path(mrk, prd, day), mrk, prd, start, end, trades); err != nil {
+			return nil, err
+		}
+		day = day.Add(24 * time.Hour)
+	}
+	sorted := make([]*market.Trade, 0, len(trades))
+	for _, t := range trades {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].TradeID < sorted[j].TradeID
+	})
+	b := &rangeBuilder{}
+	for _, t := range sorted {
+		b.add(t.TradeID, t.Time)
+	}
+	return b.ranges, nil
+}
+
+// read adds the trades of a day's file within start and end to trades,
+// later rows replace earlier ones with the same id
+func (p *archive) read(fp, mrk, prd string, start, end time.Time, trades map[string]*market.Trade) error {
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	cr := csv.NewReader(gr)
+	cr.FieldsPerRecord = 6
+	cr.ReuseRecord = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t, err := parseArchiveRecord(rec)
+		if err != nil {
+			return err
+		}
+		if t.Time.Before(start) || t.Time.After(end) {
+			continue
+		}
+		t.Market = mrk
+		t.Product = prd
+		trades[t.ID] = t
+	}
+}
+
+func parseArchiveRecord(rec []string) (*market.Trade, error) {
+	tid, err := strconv.Atoi(rec[1])
+	if err != nil {
+		return nil, err
+	}
+	tm, err := time.Parse(time.RFC3339Nano, rec[2])
+	if err != nil {
+		return nil, err
+	}
+	price, err := strconv.ParseFloat(rec[3], 64)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseFloat(rec[4], 64)
+	if err != nil {
+		return nil, err
+	}
+	return &market.Trade{
+		ID:       rec[0],
+		TradeID:  tid,
+		Time:     tm,
+		Price:    price,
+		Size:     size,
+		Side:     rec[5],
+		Historic: true,
+	}, nil
+}
+
+func (p *archive) PutBookUpdate(updates ...*market.BookUpdate) error {
+	p.Lock()
+	defer p.Unlock()
+	// group updates by file, keeping their order
+	files := map[string][][]string{}
+	order := []string{}
+	for _, u := range updates {
+		bids, _ := json.Marshal(u.Bids)
+		asks, _ := json.Marshal(u.Asks)
+		fp := p.bookPath(u.Market, u.Product, u.Time)
+		if _, ok := files[fp]; !ok {
+			order = append(order, fp)
+		}
+		files[fp] = append(files[fp], []string{
+			u.Time.UTC().Format(time.RFC3339Nano),
+			strconv.FormatInt(u.Sequence, 10),
+			u.Type,
+			string(bids),
+			string(asks),
+		})
+	}
+	for _, fp := range order {
+		if err := p.appendRows(fp, files[fp]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBookSnapshot goes back one day's file at a time, starting from the
+// given time's day, until it finds a snapshot
+func (p *archive) GetBookSnapshot(mrk, prd string, at time.Time) (*market.BookUpdate, error) {
+	p.RLock()
+	defer p.RUnlock()
+	dir := filepath.Dir(p.bookPath(mrk, prd, at))
+	files, err := filepath.Glob(filepath.Join(dir, "*"+archiveExtension))
+	if err != nil {
+		return nil, err
+	}
+	last := filepath.Base(p.bookPath(mrk, prd, at))
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	for _, fp := range files {
+		if filepath.Base(fp) > last {
+			continue
+		}
+		updates, err := p.readBook(fp, mrk, prd, time.Time{}, at)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(updates) - 1; i >= 0; i-- {
+			if updates[i].Type == market.BookSnapshot {
+				return updates[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// StreamBookUpdates reads one day's file at a time like StreamTrades
+func (p *archive) StreamBookUpdates(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.BookUpdate, <-chan error) {
+	return newBookStream(ctx, func(send func(*market.BookUpdate) bool) error {
+		day := start.UTC().Truncate(24 * time.Hour)
+		for !day.After(end) {
+			p.RLock()
+			updates, err := p.readBook(p.bookPath(mrk, prd, day), mrk, prd, start, end)
+			p.RUnlock()
+			if err != nil {
+				return err
+			}
+			for _, u := range updates {
+				if !send(u) {
+					return nil
+				}
+			}
+			day = day.Add(24 * time.Hour)
+		}
+		return nil
+	})
+}
+
+// readBook returns the updates of a day's file within start and end, sorted
+// by time, sequence, and then the order they were written in
+func (p *archive) readBook(fp, mrk, prd string, start, end time.Time) ([]*market.BookUpdate, error) {
+	updates := []*market.BookUpdate{}
+	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return updates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	cr := csv.NewReader(gr)
+	cr.FieldsPerRecord = 5
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tm, err := time.Parse(time.RFC3339Nano, rec[0])
+		if err != nil {
+			return nil, err
+		}
+		if tm.Before(start) || tm.After(end) {
+			continue
+		}
+		seq, err := strconv.ParseInt(rec[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		u := &market.BookUpdate{
+			Market:   mrk,
+			Product:  prd,
+			Time:     tm,
+			Sequence: seq,
+			Type:     rec[2],
+		}
+		if err := json.Unmarshal([]byte(rec[3]), &u.Bids); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(rec[4]), &u.Asks); err != nil {
+			return nil, err
+		}
+		updates = append(updates, u)
+	}
+	sort.SliceStable(updates, func(i, j int) bool {
+		return bookUpdateBefore(updates[i], updates[j])
+	})
+	return updates, nil
+}
@@ -0,0 +1,205 @@
+package persistence
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+// archiveMembers counts the gzip members of a file
+func archiveMembers(fp string) (int, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// the reader needs to stay the same between members, so that gzip does
+	// not read ahead of where it resets
+	br := bufio.NewReader(f)
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		return 0, err
+	}
+	members := 0
+	for {
+		gr.Multistream(false)
+		if _, err := io.Copy(ioutil.Discard, gr); err != nil {
+			return 0, err
+		}
+		members++
+		if err := gr.Reset(br); err == io.EOF {
+			return members, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// archiveTradeIDs returns the trade ids the archive has for the first hours
+func archiveTradeIDs(p Persistence) ([]int, error) {
+	trades, err := p.GetTrades("gdax", "BTC-USD", memoryTestStart, memoryTestStart.Add(2*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for _, t := range trades {
+		ids = append(ids, t.TradeID)
+	}
+	return ids, nil
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every put appends a member to the day's file
+	if err := p.PutTrade(memoryTestTrades(5, 1, 3)...); err != nil {
+		t.Fatal(err)
+	}
+	moved := memoryTestTrade(1)
+	moved.Time = memoryTestStart.Add(4 * time.Minute)
+	if err := p.PutTrade(append(memoryTestTrades(2, 4, 3), moved)...); err != nil {
+		t.Fatal(err)
+	}
+	fp := p.(*archive).path("gdax", "BTC-USD", memoryTestStart)
+	if members, err := archiveMembers(fp); err != nil || members != 2 {
+		t.Fatalf("expected 2 members, got %d %v", members, err)
+	}
+	ids, err := archiveTradeIDs(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint([]int{2, 3, 1, 4, 5}) {
+		t.Errorf("expected trades 2 3 1 4 5 before compacting, got %v", ids)
+	}
+
+	// moving on to another file compacts the day's file
+	next := memoryTestTrade(1500)
+	if err := p.PutTrade(next); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := archiveMembers(fp); err != nil || members != 1 {
+		t.Fatalf("expected 1 member after compacting, got %d %v", members, err)
+	}
+	rows, err := readRows(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 {
+		t.Errorf("expected compacting to remove the duplicates, got %d rows", len(rows))
+	}
+	ids, err = archiveTradeIDs(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint([]int{2, 3, 1, 4, 5}) {
+		t.Errorf("expected trades 2 3 1 4 5 after compacting, got %v", ids)
+	}
+
+	// a new archive over the same directory appends to the compacted file
+	p, err = NewArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutTrade(memoryTestTrades(9, 6)...); err != nil {
+		t.Fatal(err)
+	}
+	ids, err = archiveTradeIDs(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint([]int{2, 3, 1, 4, 5, 6, 9}) {
+		t.Errorf("expected trades 2 3 1 4 5 6 9 after re-opening, got %v", ids)
+	}
+	ranges, err := p.GetTradeRanges("gdax", "BTC-USD", memoryTestStart, memoryTestStart.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := [][2]int{}
+	for _, r := range ranges {
+		got = append(got, [2]int{r.First, r.Last})
+	}
+	if fmt.Sprint(got) != fmt.Sprint([][2]int{{1, 6}, {9, 9}, {1500, 1500}}) {
+		t.Errorf("expected ranges 1-6 9-9 1500-1500, got %v", got)
+	}
+}
+
+func TestArchiveBookUpdates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(m int, typ string) *market.BookUpdate {
+		return &market.BookUpdate{
+			Market:  "gdax",
+			Product: "BTC-USD",
+			Type:    typ,
+			Time:    memoryTestStart.Add(time.Duration(m) * time.Minute),
+			Bids:    []market.BookLevel{{Price: 100, Size: 1}},
+			Asks:    []market.BookLevel{},
+		}
+	}
+	if err := p.PutBookUpdate(update(2, "a"), update(1, "b"), update(2, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutBookUpdate(update(1, "d"), update(3, "e"), update(2, "f")); err != nil {
+		t.Fatal(err)
+	}
+	// compacting keeps the updates as they are
+	if err := p.PutTrade(memoryTestTrade(1)); err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, errs := p.StreamBookUpdates(context.Background(), "gdax", "BTC-USD", memoryTestStart, memoryTestStart.Add(time.Hour))
+	types := ""
+	for u := range updates {
+		types += u.Type
+		if len(u.Bids) != 1 || u.Bids[0].Price != 100 {
+			t.Errorf("expected update %s to keep its levels, got %+v", u.Type, u)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	// updates with the same time keep the order they were put in
+	if types != "bdacfe" {
+		t.Errorf("expected updates bdacfe, got %s", types)
+	}
+}
+
+func TestArchiveGetBookSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p, err := NewArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGetBookSnapshot(t, p)
+}
@@ -0,0 +1,319 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	memoryLoadBatch = 1000
+)
+
+// NewMemory creates a persistence that keeps trades in memory, sorted by time
+// and trade id for each market and product
+func NewMemory() (Persistence, error) {
+	return &memory{
+		products: map[string]*memoryProduct{},
+		books:    map[string][]*market.BookUpdate{},
+	}, nil
+}
+
+type memory struct {
+	sync.RWMutex
+	products map[string]*memoryProduct
+	// books holds the book updates of each market and product sorted by time
+	// and sequence
+	books map[string][]*market.BookUpdate
+}
+
+type memoryProduct struct {
+	trades []*market.Trade
+	byID   map[string]*market.Trade
+}
+
+func memoryKey(mrk, prd string) string {
+	return mrk + "." + prd
+}
+
+func (p *memory) PutTrade(trades ...*market.Trade) error {
+	p.Lock()
+	defer p.Unlock()
+	batches := map[string][]*market.Trade{}
+	for _, t := range trades {
+		key := memoryKey(t.Market, t.Product)
+		nt := *t
+		nt.Historic = true
+		batches[key] = append(batches[key], &nt)
+	}
+	for key, batch := range batches {
+		mp, ok := p.products[key]
+		if !ok {
+			mp = &memoryProduct{
+				trades: []*market.Trade{},
+				byID:   map[string]*market.Trade{},
+			}
+			p.products[key] = mp
+		}
+		mp.put(batch)
+	}
+	return nil
+}
+
+// put merges a batch of trades into the sorted trades, replacing any trades
+// with the same ids
+func (mp *memoryProduct) put(batch []*market.Trade) {
+	replaced := map[*market.Trade]bool{}
+	added := map[string]*market.Trade{}
+	for _, t := range batch {
+		if old, ok := mp.byID[t.ID]; ok {
+			replaced[old] = true
+		}
+		mp.byID[t.ID] = t
+		added[t.ID] = t
+	}
+	// only the last trade of the batch with each id is kept
+	unique := make([]*market.Trade, 0, len(added))
+	for _, t := range batch {
+		if added[t.ID] == t {
+			unique = append(unique, t)
+		}
+	}
+	sortTrades(unique)
+	if len(replaced) > 0 {
+		kept := mp.trades[:0]
+		for _, t := range mp.trades {
+			if !replaced[t] {
+				kept = append(kept, t)
+			}
+		}
+		mp.trades = kept
+	}
+	// most batches come after the trades we have so try appending first
+	n := len(mp.trades)
+	if n == 0 || !tradeBefore(unique[0], mp.trades[n-1]) {
+		mp.trades = append(mp.trades, unique...)
+		return
+	}
+	merged := make([]*market.Trade, 0, n+len(unique))
+	i, j := 0, 0
+	for i < n && j < len(unique) {
+		if tradeBefore(unique[j], mp.trades[i]) {
+			merged = append(merged, unique[j])
+			j++
+			continue
+		}
+		merged = append(merged, mp.trades[i])
+		i++
+	}
+	merged = append(merged, mp.trades[i:]...)
+	mp.trades = append(merged, unique[j:]...)
+}
+
+func (p *memory) GetTrades(mrk, prd string, start, end time.Time) ([]*market.Trade, error) {
+	p.RLock()
+	defer p.RUnlock()
+	mp, ok := p.products[memoryKey(mrk, prd)]
+	if !ok {
+		return []*market.Trade{}, nil
+	}
+	from := sort.Search(len(mp.trades), func(i int) bool {
+		return !mp.trades[i].Time.Before(start)
+	})
+	to := sort.Search(len(mp.trades), func(i int) bool {
+		return mp.trades[i].Time.After(end)
+	})
+	trades := make([]*market.Trade, 0, to-from)
+	for _, t := range mp.trades[from:to] {
+		nt := *t
+		trades = append(trades, &nt)
+	}
+	return trades, nil
+}
+
+// StreamTrades copies the trades out in batches, so that puts are only
+// blocked while a batch is being copied
+func (p *memory) StreamTrades(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.Trade, <-chan error) {
+	return newStream(ctx, func(send func(*market.Trade) bool) error {
+		var last *market.Trade
+		for {
+			batch := p.batch(mrk, prd, start, end, last)
+			if len(batch) == 0 {
+				return nil
+			}
+			for _, t := range batch {
+				if !send(t) {
+					return nil
+				}
+			}
+			last = batch[len(batch)-1]
+		}
+	})
+}
+
+// batch returns copies of up to streamBuffer trades between start and end
+// that come after the given trade, or from the start if it is nil
+func (p *memory) batch(mrk, prd string, start, end time.Time, after *market.Trade) []*market.Trade {
+	p.RLock()
+	defer p.RUnlock()
+	mp, ok := p.products[memoryKey(mrk, prd)]
+	if !ok {
+		return nil
+	}
+	from := sort.Search(len(mp.trades), func(i int) bool {
+		if after != nil {
+			return tradeBefore(after, mp.trades[i])
+		}
+		return !mp.trades[i].Time.Before(start)
+	})
+	trades := []*market.Trade{}
+	for _, t := range mp.trades[from:] {
+		if t.Time.After(end) || len(trades) >= streamBuffer {
+			break
+		}
+		nt := *t
+		trades = append(trades, &nt)
+	}
+	return trades
+}
+
+func (p *memory) GetTradeRanges(mrk, prd string, start, end time.Time) ([]*TradeRange, error) {
+	p.RLock()
+	mp, ok := p.products[memoryKey(mrk, prd)]
+	if !ok {
+		p.RUnlock()
+		return []*TradeRange{}, nil
+	}
+	from := sort.Search(len(mp.trades), func(i int) bool {
+		return !mp.trades[i].Time.Before(start)
+	})
+	to := sort.Search(len(mp.trades), func(i int) bool {
+		return mp.trades[i].Time.After(end)
+	})
+	trades := append([]*market.Trade{}, mp.trades[from:to]...)
+	p.RUnlock()
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].TradeID < trades[j].TradeID
+	})
+	b := &rangeBuilder{}
+	for _, t := range trades {
+		b.add(t.TradeID, t.Time)
+	}
+	return b.ranges, nil
+}
+
+func (p *memory) PutBookUpdate(updates ...*market.BookUpdate) error {
+	p.Lock()
+	defer p.Unlock()
+	batches := map[string][]*market.BookUpdate{}
+	for _, u := range updates {
+		key := memoryKey(u.Market, u.Product)
+		batches[key] = append(batches[key], u)
+	}
+	for key, batch := range batches {
+		sort.SliceStable(batch, func(i, j int) bool {
+			return bookUpdateBefore(batch[i], batch[j])
+		})
+		books := p.books[key]
+		n := len(books)
+		if n == 0 || !bookUpdateBefore(batch[0], books[n-1]) {
+			p.books[key] = append(books, batch...)
+			continue
+		}
+		// updates go after any updates we have with the same time and
+		// sequence
+		merged := make([]*market.BookUpdate, 0, n+len(batch))
+		i, j := 0, 0
+		for i < n && j < len(batch) {
+			if bookUpdateBefore(batch[j], books[i]) {
+				merged = append(merged, batch[j])
+				j++
+				continue
+			}
+			merged = append(merged, books[i])
+			i++
+		}
+		merged = append(merged, books[i:]...)
+		p.books[key] = append(merged, batch[j:]...)
+	}
+	return nil
+}
+
+func (p *memory) StreamBookUpdates(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.BookUpdate, <-chan error) {
+	p.RLock()
+	books := p.books[memoryKey(mrk, prd)]
+	from := sort.Search(len(books), func(i int) bool {
+		return !books[i].Time.Before(start)
+	})
+	to := sort.Search(len(books), func(i int) bool {
+		return books[i].Time.After(end)
+	})
+	// copy the pointers as puts shift the slice around
+	books = append([]*market.BookUpdate{}, books[from:to]...)
+	p.RUnlock()
+	return newBookStream(ctx, func(send func(*market.BookUpdate) bool) error {
+		for _, u := range books {
+			if !send(u) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (p *memory) GetBookSnapshot(mrk, prd string, at time.Time) (*market.BookUpdate, error) {
+	p.RLock()
+	defer p.RUnlock()
+	books := p.books[memoryKey(mrk, prd)]
+	to := sort.Search(len(books), func(i int) bool {
+		return books[i].Time.After(at)
+	})
+	for i := to - 1; i >= 0; i-- {
+		if books[i].Type == market.BookSnapshot {
+			return books[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// LoadJSONLines reads trades as json, one per line, in the format gdax lists
+// them and puts them in the given persistence under the given market and
+// product
+func LoadJSONLines(p Persistence, mrk, prd string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	trades := []*market.Trade{}
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		t := &market.Trade{}
+		if err := json.Unmarshal(line, t); err != nil {
+			return err
+		}
+		t.Market = mrk
+		t.Product = prd
+		t.ID = fmt.Sprintf("%s.%s.%d", mrk, prd, t.TradeID)
+		trades = append(trades, t)
+		if len(trades) >= memoryLoadBatch {
+			if err := p.PutTrade(trades...); err != nil {
+				return err
+			}
+			trades = []*market.Trade{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(trades) == 0 {
+		return nil
+	}
+	return p.PutTrade(trades...)
+}
@@ -0,0 +1,206 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+var memoryTestStart = time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// memoryTestTrade creates a trade whose time in minutes matches its id
+func memoryTestTrade(tradeID int) *market.Trade {
+	return &market.Trade{
+		ID:      fmt.Sprintf("gdax.BTC-USD.%d", tradeID),
+		Market:  "gdax",
+		Product: "BTC-USD",
+		TradeID: tradeID,
+		Time:    memoryTestStart.Add(time.Duration(tradeID) * time.Minute),
+		Price:   100,
+		Size:    1,
+	}
+}
+
+func memoryTestTrades(tradeIDs ...int) []*market.Trade {
+	trades := []*market.Trade{}
+	for _, id := range tradeIDs {
+		trades = append(trades, memoryTestTrade(id))
+	}
+	return trades
+}
+
+func TestMemoryGetTradeRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]int
+		start   int
+		end     int
+		ranges  [][2]int
+	}{
+		{
+			name:    "nothing stored",
+			batches: [][]int{},
+			end:     100,
+			ranges:  [][2]int{},
+		},
+		{
+			name:    "batches out of order with duplicates",
+			batches: [][]int{{7, 8, 9}, {1, 3, 2}, {3, 4, 12}},
+			end:     100,
+			ranges:  [][2]int{{1, 4}, {7, 9}, {12, 12}},
+		},
+		{
+			name:    "window",
+			batches: [][]int{{1, 2, 3, 4, 5, 8, 9, 10}},
+			start:   3,
+			end:     8,
+			ranges:  [][2]int{{3, 5}, {8, 8}},
+		},
+	}
+	for _, test := range tests {
+		p, _ := NewMemory()
+		for _, batch := range test.batches {
+			if err := p.PutTrade(memoryTestTrades(batch...)...); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		start := memoryTestStart.Add(time.Duration(test.start) * time.Minute)
+		end := memoryTestStart.Add(time.Duration(test.end) * time.Minute)
+		ranges, err := p.GetTradeRanges("gdax", "BTC-USD", start, end)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got := [][2]int{}
+		for _, r := range ranges {
+			got = append(got, [2]int{r.First, r.Last})
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.ranges) {
+			t.Errorf("%s: expected ranges %v, got %v", test.name, test.ranges, got)
+		}
+	}
+}
+
+func TestMemoryStreamTrades(t *testing.T) {
+	p, _ := NewMemory()
+	if err := p.PutTrade(memoryTestTrades(5, 1, 3)...); err != nil {
+		t.Fatal(err)
+	}
+	// replacing a trade moves it to its new time
+	moved := memoryTestTrade(1)
+	moved.Time = memoryTestStart.Add(4 * time.Minute)
+	if err := p.PutTrade(append(memoryTestTrades(2, 4), moved)...); err != nil {
+		t.Fatal(err)
+	}
+	trades, errs := p.StreamTrades(context.Background(), "gdax", "BTC-USD", memoryTestStart, memoryTestStart.Add(time.Hour))
+	ids := []int{}
+	for trade := range trades {
+		ids = append(ids, trade.TradeID)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint([]int{2, 3, 1, 4, 5}) {
+		t.Errorf("expected trades 2 3 1 4 5, got %v", ids)
+	}
+}
+
+func TestMemoryStreamBookUpdates(t *testing.T) {
+	p, _ := NewMemory()
+	update := func(m int, typ string) *market.BookUpdate {
+		return &market.BookUpdate{
+			Market:  "gdax",
+			Product: "BTC-USD",
+			Type:    typ,
+			Time:    memoryTestStart.Add(time.Duration(m) * time.Minute),
+		}
+	}
+	if err := p.PutBookUpdate(update(2, "a"), update(1, "b"), update(2, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutBookUpdate(update(1, "d"), update(3, "e"), update(2, "f")); err != nil {
+		t.Fatal(err)
+	}
+	updates, errs := p.StreamBookUpdates(context.Background(), "gdax", "BTC-USD", memoryTestStart, memoryTestStart.Add(time.Hour))
+	types := ""
+	for u := range updates {
+		types += u.Type
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	// updates with the same time keep the order they were put in
+	if types != "bdacfe" {
+		t.Errorf("expected updates bdacfe, got %s", types)
+	}
+}
+
+func TestMemoryGetBookSnapshot(t *testing.T) {
+	p, _ := NewMemory()
+	testGetBookSnapshot(t, p)
+}
+
+// testGetBookSnapshot checks that a persistence finds the latest snapshot,
+// and orders updates with the same time by their sequence
+func testGetBookSnapshot(t *testing.T, p Persistence) {
+	update := func(m int, seq int64, typ string) *market.BookUpdate {
+		return &market.BookUpdate{
+			Market:   "gdax",
+			Product:  "BTC-USD",
+			Type:     typ,
+			Time:     memoryTestStart.Add(time.Duration(m) * time.Minute),
+			Sequence: seq,
+			Bids:     []market.BookLevel{},
+			Asks:     []market.BookLevel{},
+		}
+	}
+	if err := p.PutBookUpdate(
+		update(-2*24*60, 1, market.BookChange),
+		update(-24*60, 2, market.BookSnapshot),
+		update(1, 3, market.BookSnapshot),
+		update(2, 4, market.BookChange),
+		update(3, 6, market.BookSnapshot),
+		update(3, 5, market.BookSnapshot),
+		update(4, 7, market.BookChange),
+	); err != nil {
+		t.Fatal(err)
+	}
+	updates, errs := p.StreamBookUpdates(context.Background(), "gdax", "BTC-USD", memoryTestStart.Add(-72*time.Hour), memoryTestStart.Add(time.Hour))
+	seqs := []int64{}
+	for u := range updates {
+		seqs = append(seqs, u.Sequence)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(seqs) != fmt.Sprint([]int64{1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("expected updates 1 to 7, got %v", seqs)
+	}
+	tests := []struct {
+		name string
+		at   int
+		seq  int64
+	}{
+		{"before any snapshot", -2 * 24 * 60, 0},
+		{"on an earlier day", 0, 2},
+		{"at a snapshot", 1, 3},
+		{"after a change", 2, 3},
+		{"the last of the same time", 3, 6},
+		{"after the last snapshot", 10, 6},
+	}
+	for _, test := range tests {
+		snap, err := p.GetBookSnapshot("gdax", "BTC-USD", memoryTestStart.Add(time.Duration(test.at)*time.Minute))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		seq := int64(0)
+		if snap != nil {
+			seq = snap.Sequence
+		}
+		if seq != test.seq {
+			t.Errorf("%s: expected snapshot %d, got %d", test.name, test.seq, seq)
+		}
+	}
+}
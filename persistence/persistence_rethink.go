@@ -1,6 +1,8 @@
 package persistence
 
 import (
+	"context"
+	"sort"
 	"time"
 
 	r "gopkg.in/gorethink/gorethink.v3"
@@ -11,6 +13,8 @@ import (
 const (
 	rethinkdbTradesTable     = "trades"
 	rethinkdbTradesTimeIndex = "time"
+	rethinkdbBookTable       = "book_updates"
+	rethinkdbBookTimeIndex   = "time"
 )
 
 // NewRethinkDB -
@@ -62,3 +66,182 @@ func (p *rethinkdb) GetTrades(mrk, prd string, start, end time.Time) ([]*market.
 	}
 	return trades, nil
 }
+
+// StreamTrades walks the time index with a cursor so that rethinkdb doesn't
+// need to load and sort the whole range in memory, the index only orders by
+// time so trades with the same time are buffered and sorted by trade id
+func (p *rethinkdb) StreamTrades(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.Trade, <-chan error) {
+	return newStream(ctx, func(send func(*market.Trade) bool) error {
+		cur, err := r.DB(p.database).Table(rethinkdbTradesTable).
+			Between(start, end, r.BetweenOpts{
+				Index:      rethinkdbTradesTimeIndex,
+				RightBound: "closed",
+			}).
+			OrderBy(r.OrderByOpts{
+				Index: r.Asc(rethinkdbTradesTimeIndex),
+			}).
+			Filter(map[string]interface{}{
+				"market":  mrk,
+				"product": prd,
+			}).
+			Run(p.session)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		same := []*market.Trade{}
+		flush := func() bool {
+			sortTrades(same)
+			for _, t := range same {
+				if !send(t) {
+					return false
+				}
+			}
+			same = []*market.Trade{}
+			return true
+		}
+		trade := &market.Trade{}
+		for cur.Next(trade) {
+			trade.Historic = true
+			if len(same) > 0 && !same[0].Time.Equal(trade.Time) {
+				if !flush() {
+					return nil
+				}
+			}
+			same = append(same, trade)
+			trade = &market.Trade{}
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	})
+}
+
+// GetTradeRanges walks the time index between start and end, the trade ids
+// are sorted once we have them
+func (p *rethinkdb) GetTradeRanges(mrk, prd string, start, end time.Time) ([]*TradeRange, error) {
+	cur, err := r.DB(p.database).Table(rethinkdbTradesTable).
+		Between(start, end, r.BetweenOpts{
+			Index:      rethinkdbTradesTimeIndex,
+			RightBound: "closed",
+		}).
+		Filter(map[string]interface{}{
+			"market":  mrk,
+			"product": prd,
+		}).
+		Pluck("trade_id", "time").
+		Run(p.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	trades := []*market.Trade{}
+	if err := cur.All(&trades); err != nil {
+		return nil, err
+	}
+	sort.Slice(trades, func(i, j int) bool {
+		return trades[i].TradeID < trades[j].TradeID
+	})
+	b := &rangeBuilder{}
+	for _, t := range trades {
+		b.add(t.TradeID, t.Time)
+	}
+	return b.ranges, nil
+}
+
+func (p *rethinkdb) PutBookUpdate(updates ...*market.BookUpdate) error {
+	_, err := r.DB(p.database).
+		Table(rethinkdbBookTable).
+		Insert(updates).
+		RunWrite(p.session)
+	return err
+}
+
+// GetBookSnapshot walks the time index back from the given time, snapshots
+// with the same time are compared by their sequence
+func (p *rethinkdb) GetBookSnapshot(mrk, prd string, at time.Time) (*market.BookUpdate, error) {
+	cur, err := r.DB(p.database).Table(rethinkdbBookTable).
+		Between(r.MinVal, at, r.BetweenOpts{
+			Index:      rethinkdbBookTimeIndex,
+			RightBound: "closed",
+		}).
+		OrderBy(r.OrderByOpts{
+			Index: r.Desc(rethinkdbBookTimeIndex),
+		}).
+		Filter(map[string]interface{}{
+			"market":  mrk,
+			"product": prd,
+			"type":    market.BookSnapshot,
+		}).
+		Run(p.session)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+	var latest *market.BookUpdate
+	u := &market.BookUpdate{}
+	for cur.Next(u) {
+		if latest != nil && !latest.Time.Equal(u.Time) {
+			break
+		}
+		if latest == nil || latest.Sequence < u.Sequence {
+			latest = u
+		}
+		u = &market.BookUpdate{}
+	}
+	return latest, cur.Err()
+}
+
+// StreamBookUpdates walks the time index, the index only orders by time so
+// updates with the same time are buffered and sorted by sequence
+func (p *rethinkdb) StreamBookUpdates(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.BookUpdate, <-chan error) {
+	return newBookStream(ctx, func(send func(*market.BookUpdate) bool) error {
+		cur, err := r.DB(p.database).Table(rethinkdbBookTable).
+			Between(start, end, r.BetweenOpts{
+				Index:      rethinkdbBookTimeIndex,
+				RightBound: "closed",
+			}).
+			OrderBy(r.OrderByOpts{
+				Index: r.Asc(rethinkdbBookTimeIndex),
+			}).
+			Filter(map[string]interface{}{
+				"market":  mrk,
+				"product": prd,
+			}).
+			Run(p.session)
+		if err != nil {
+			return err
+		}
+		defer cur.Close()
+		same := []*market.BookUpdate{}
+		flush := func() bool {
+			sort.SliceStable(same, func(i, j int) bool {
+				return bookUpdateBefore(same[i], same[j])
+			})
+			for _, u := range same {
+				if !send(u) {
+					return false
+				}
+			}
+			same = []*market.BookUpdate{}
+			return true
+		}
+		u := &market.BookUpdate{}
+		for cur.Next(u) {
+			if len(same) > 0 && !same[0].Time.Equal(u.Time) {
+				if !flush() {
+					return nil
+				}
+			}
+			same = append(same, u)
+			u = &market.BookUpdate{}
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	})
+}
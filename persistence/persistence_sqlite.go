@@ -0,0 +1,294 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	// sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	sqliteSchema = `
+CREATE TABLE IF NOT EXISTS trades (
+	id       TEXT PRIMARY KEY,
+	market   TEXT NOT NULL,
+	product  TEXT NOT NULL,
+	trade_id INTEGER NOT NULL,
+	price    REAL NOT NULL,
+	size     REAL NOT NULL,
+	time     INTEGER NOT NULL,
+	side     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trades_market_product_time_trade_id
+	ON trades (market, product, time, trade_id);
+CREATE INDEX IF NOT EXISTS trades_market_product_trade_id
+	ON trades (market, product, trade_id);
+CREATE TABLE IF NOT EXISTS book_updates (
+	market   TEXT NOT NULL,
+	product  TEXT NOT NULL,
+	time     INTEGER NOT NULL,
+	sequence INTEGER NOT NULL,
+	type     TEXT NOT NULL,
+	bids     TEXT NOT NULL,
+	asks     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS book_updates_market_product_time
+	ON book_updates (market, product, time);
+`
+	sqliteInsertTrade = `
+INSERT OR REPLACE INTO trades (id, market, product, trade_id, price, size, time, side)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+	sqliteSelectTrades = `
+SELECT id, market, product, trade_id, price, size, time, side
+FROM trades
+WHERE market = ? AND product = ? AND time >= ? AND time <= ?
+ORDER BY time ASC, trade_id ASC
+`
+	sqliteInsertBookUpdate = `
+INSERT INTO book_updates (market, product, time, sequence, type, bids, asks)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+`
+	sqliteSelectBookUpdates = `
+SELECT market, product, time, sequence, type, bids, asks
+FROM book_updates
+WHERE market = ? AND product = ? AND time >= ? AND time <= ?
+ORDER BY time ASC, sequence ASC, rowid ASC
+`
+	sqliteSelectBookSnapshot = `
+SELECT market, product, time, sequence, type, bids, asks
+FROM book_updates
+WHERE market = ? AND product = ? AND time <= ? AND type = ?
+ORDER BY time DESC, sequence DESC, rowid DESC
+LIMIT 1
+`
+	sqliteSelectTradeIDs = `
+SELECT trade_id, time
+FROM trades
+WHERE market = ? AND product = ? AND time >= ? AND time <= ?
+ORDER BY trade_id ASC
+`
+)
+
+// NewSQLite creates a persistence backed by an sqlite database at the given
+// path, creating the database if it does not exist
+func NewSQLite(path string) (Persistence, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlite{
+		db: db,
+	}, nil
+}
+
+type sqlite struct {
+	db *sql.DB
+}
+
+func (p *sqlite) PutTrade(trades ...*market.Trade) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(sqliteInsertTrade)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range trades {
+		if _, err := stmt.Exec(
+			t.ID,
+			t.Market,
+			t.Product,
+			t.TradeID,
+			t.Price,
+			t.Size,
+			t.Time.UnixNano(),
+			t.Side,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *sqlite) GetTrades(mrk, prd string, start, end time.Time) ([]*market.Trade, error) {
+	rows, err := p.db.Query(sqliteSelectTrades, mrk, prd, start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	trades := []*market.Trade{}
+	for rows.Next() {
+		t, err := scanSQLiteTrade(rows)
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+func (p *sqlite) StreamTrades(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.Trade, <-chan error) {
+	return newStream(ctx, func(send func(*market.Trade) bool) error {
+		rows, err := p.db.QueryContext(ctx, sqliteSelectTrades, mrk, prd, start.UnixNano(), end.UnixNano())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			t, err := scanSQLiteTrade(rows)
+			if err != nil {
+				return err
+			}
+			if !send(t) {
+				return nil
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func (p *sqlite) GetTradeRanges(mrk, prd string, start, end time.Time) ([]*TradeRange, error) {
+	rows, err := p.db.Query(sqliteSelectTradeIDs, mrk, prd, start.UnixNano(), end.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	b := &rangeBuilder{}
+	for rows.Next() {
+		var tid int
+		var ts int64
+		if err := rows.Scan(&tid, &ts); err != nil {
+			return nil, err
+		}
+		b.add(tid, time.Unix(0, ts).UTC())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return b.ranges, nil
+}
+
+func (p *sqlite) PutBookUpdate(updates ...*market.BookUpdate) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(sqliteInsertBookUpdate)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range updates {
+		bids, _ := json.Marshal(u.Bids)
+		asks, _ := json.Marshal(u.Asks)
+		if _, err := stmt.Exec(
+			u.Market,
+			u.Product,
+			u.Time.UnixNano(),
+			u.Sequence,
+			u.Type,
+			string(bids),
+			string(asks),
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (p *sqlite) GetBookSnapshot(mrk, prd string, at time.Time) (*market.BookUpdate, error) {
+	rows, err := p.db.Query(sqliteSelectBookSnapshot, mrk, prd, at.UnixNano(), market.BookSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanSQLiteBookUpdate(rows)
+}
+
+func (p *sqlite) StreamBookUpdates(ctx context.Context, mrk, prd string, start, end time.Time) (<-chan *market.BookUpdate, <-chan error) {
+	return newBookStream(ctx, func(send func(*market.BookUpdate) bool) error {
+		rows, err := p.db.QueryContext(ctx, sqliteSelectBookUpdates, mrk, prd, start.UnixNano(), end.UnixNano())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			u, err := scanSQLiteBookUpdate(rows)
+			if err != nil {
+				return err
+			}
+			if !send(u) {
+				return nil
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func scanSQLiteBookUpdate(rows *sql.Rows) (*market.BookUpdate, error) {
+	u := &market.BookUpdate{}
+	var ts int64
+	var bids, asks string
+	if err := rows.Scan(
+		&u.Market,
+		&u.Product,
+		&ts,
+		&u.Sequence,
+		&u.Type,
+		&bids,
+		&asks,
+	); err != nil {
+		return nil, err
+	}
+	u.Time = time.Unix(0, ts).UTC()
+	if err := json.Unmarshal([]byte(bids), &u.Bids); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(asks), &u.Asks); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func scanSQLiteTrade(rows *sql.Rows) (*market.Trade, error) {
+	t := &market.Trade{
+		Historic: true,
+	}
+	var ts int64
+	if err := rows.Scan(
+		&t.ID,
+		&t.Market,
+		&t.Product,
+		&t.TradeID,
+		&t.Price,
+		&t.Size,
+		&ts,
+		&t.Side,
+	); err != nil {
+		return nil, err
+	}
+	t.Time = time.Unix(0, ts).UTC()
+	return t, nil
+}
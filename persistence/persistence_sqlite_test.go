@@ -0,0 +1,122 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trades.db")
+
+	p, err := NewSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutTrade(memoryTestTrades(5, 1, 3, 9)...); err != nil {
+		t.Fatal(err)
+	}
+	// replacing a trade moves it to its new time
+	moved := memoryTestTrade(1)
+	moved.Time = memoryTestStart.Add(4 * time.Minute)
+	moved.Side = "sell"
+	if err := p.PutTrade(append(memoryTestTrades(2, 4), moved)...); err != nil {
+		t.Fatal(err)
+	}
+	update := func(m int, typ string, price float64) *market.BookUpdate {
+		return &market.BookUpdate{
+			Market:  "gdax",
+			Product: "BTC-USD",
+			Type:    typ,
+			Time:    memoryTestStart.Add(time.Duration(m) * time.Minute),
+			Bids:    []market.BookLevel{{Price: price, Size: 1}},
+			Asks:    []market.BookLevel{},
+		}
+	}
+	if err := p.PutBookUpdate(update(2, "a", 1), update(1, "b", 2), update(2, "c", 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything is read back from a new connection to the database
+	p, err = NewSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := memoryTestStart
+	end := memoryTestStart.Add(5 * time.Minute)
+
+	trades, err := p.GetTrades("gdax", "BTC-USD", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int{}
+	for _, tr := range trades {
+		got = append(got, tr.TradeID)
+		if tr.TradeID == 1 && (tr.Side != "sell" || !tr.Time.Equal(moved.Time) || !tr.Historic) {
+			t.Errorf("expected trade 1 to be replaced, got %+v", tr)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint([]int{2, 3, 1, 4, 5}) {
+		t.Errorf("expected trades 2 3 1 4 5, got %v", got)
+	}
+
+	stream, errs := p.StreamTrades(context.Background(), "gdax", "BTC-USD", start, end)
+	got = []int{}
+	for tr := range stream {
+		got = append(got, tr.TradeID)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint([]int{2, 3, 1, 4, 5}) {
+		t.Errorf("expected to stream trades 2 3 1 4 5, got %v", got)
+	}
+
+	ranges, err := p.GetTradeRanges("gdax", "BTC-USD", start, memoryTestStart.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 || ranges[0].First != 1 || ranges[0].Last != 5 || ranges[1].First != 9 || ranges[1].Last != 9 {
+		t.Errorf("expected ranges 1-5 and 9-9, got %d ranges", len(ranges))
+	}
+
+	updates, errs := p.StreamBookUpdates(context.Background(), "gdax", "BTC-USD", start, end)
+	types := ""
+	for u := range updates {
+		types += u.Type
+		if len(u.Bids) != 1 || len(u.Asks) != 0 {
+			t.Errorf("expected update %s to keep its levels, got %+v", u.Type, u)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	// updates with the same time keep the order they were put in
+	if types != "bac" {
+		t.Errorf("expected updates bac, got %s", types)
+	}
+}
+
+func TestSQLiteGetBookSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p, err := NewSQLite(filepath.Join(dir, "trades.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGetBookSnapshot(t, p)
+}
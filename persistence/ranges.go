@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"time"
+)
+
+// TradeRange is a run of consecutive trade ids, first and last inclusive,
+// along with the time of the first and last trade
+type TradeRange struct {
+	First int
+	Last  int
+	Start time.Time
+	End   time.Time
+}
+
+// Count returns the number of trade ids in the range
+func (r *TradeRange) Count() int {
+	return r.Last - r.First + 1
+}
+
+// Contains checks if the given trade id is within the range
+func (r *TradeRange) Contains(tradeID int) bool {
+	return tradeID >= r.First && tradeID <= r.Last
+}
+
+// Gaps returns the missing trade ids between the given ranges, which need to
+// be sorted by trade id.
+// The start and end of each gap are the times of the stored trades right
+// before and after it, so the missing trades happened somewhere in between.
+func Gaps(ranges []*TradeRange) []*TradeRange {
+	gaps := []*TradeRange{}
+	for i := 1; i < len(ranges); i++ {
+		prev, next := ranges[i-1], ranges[i]
+		if next.First <= prev.Last+1 {
+			continue
+		}
+		gaps = append(gaps, &TradeRange{
+			First: prev.Last + 1,
+			Last:  next.First - 1,
+			Start: prev.End,
+			End:   next.Start,
+		})
+	}
+	return gaps
+}
+
+// rangeBuilder folds trade ids, added in ascending order, into ranges
+type rangeBuilder struct {
+	ranges []*TradeRange
+}
+
+func (b *rangeBuilder) add(tradeID int, tm time.Time) {
+	if n := len(b.ranges); n > 0 {
+		last := b.ranges[n-1]
+		if tradeID <= last.Last {
+			return
+		}
+		if tradeID == last.Last+1 {
+			last.Last = tradeID
+			last.End = tm
+			return
+		}
+	}
+	b.ranges = append(b.ranges, &TradeRange{
+		First: tradeID,
+		Last:  tradeID,
+		Start: tm,
+		End:   tm,
+	})
+}
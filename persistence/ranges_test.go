@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGaps(t *testing.T) {
+	at := func(m int) time.Time {
+		return time.Date(2018, 1, 2, 0, m, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		ranges []*TradeRange
+		gaps   []*TradeRange
+	}{
+		{
+			name:   "no ranges",
+			ranges: []*TradeRange{},
+			gaps:   []*TradeRange{},
+		},
+		{
+			name: "consecutive",
+			ranges: []*TradeRange{
+				{First: 1, Last: 10, Start: at(0), End: at(1)},
+				{First: 11, Last: 20, Start: at(2), End: at(3)},
+			},
+			gaps: []*TradeRange{},
+		},
+		{
+			name: "holes",
+			ranges: []*TradeRange{
+				{First: 1, Last: 10, Start: at(0), End: at(1)},
+				{First: 15, Last: 20, Start: at(2), End: at(3)},
+				{First: 22, Last: 30, Start: at(4), End: at(5)},
+			},
+			gaps: []*TradeRange{
+				{First: 11, Last: 14, Start: at(1), End: at(2)},
+				{First: 21, Last: 21, Start: at(3), End: at(4)},
+			},
+		},
+	}
+	for _, test := range tests {
+		if gaps := Gaps(test.ranges); !reflect.DeepEqual(gaps, test.gaps) {
+			t.Errorf("%s: expected gaps %v, got %v", test.name, test.gaps, gaps)
+		}
+	}
+}
+
+func TestTradeRange(t *testing.T) {
+	r := &TradeRange{First: 5, Last: 9}
+	if r.Count() != 5 {
+		t.Errorf("expected a count of 5, got %d", r.Count())
+	}
+	if !r.Contains(5) || !r.Contains(9) || r.Contains(10) {
+		t.Errorf("expected the range to contain 5 to 9")
+	}
+}
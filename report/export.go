@@ -0,0 +1,97 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteEquityCSV writes the equity curve as csv
+func WriteEquityCSV(w io.Writer, points []*Point) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "asset", "currency", "price", "equity"}); err != nil {
+		return err
+	}
+	for _, p := range points {
+		if err := cw.Write([]string{
+			p.Time.Format(time.RFC3339Nano),
+			formatFloat(p.Asset),
+			formatFloat(p.Currency),
+			formatFloat(p.Price),
+			formatFloat(p.Equity),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLedgerCSV writes the ledger as csv
+func WriteLedgerCSV(w io.Writer, ledger []*Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "order_id", "action", "side", "status", "price", "size", "fee"}); err != nil {
+		return err
+	}
+	for _, e := range ledger {
+		if err := cw.Write([]string{
+			e.Time.Format(time.RFC3339Nano),
+			e.OrderID,
+			string(e.Action),
+			string(e.Side),
+			string(e.Status),
+			formatFloat(e.Price),
+			formatFloat(e.Size),
+			formatFloat(e.Fee),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SaveEquity writes the equity curve to a file, as csv if the file ends in
+// .csv or as json otherwise
+func SaveEquity(path string, points []*Point) error {
+	return save(path, points, func(w io.Writer) error {
+		return WriteEquityCSV(w, points)
+	})
+}
+
+// SaveLedger writes the ledger to a file, as csv if the file ends in .csv or
+// as json otherwise
+func SaveLedger(path string, ledger []*Entry) error {
+	return save(path, ledger, func(w io.Writer) error {
+		return WriteLedgerCSV(w, ledger)
+	})
+}
+
+func save(path string, v interface{}, writeCSV func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		if err := writeCSV(f); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
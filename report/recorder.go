@@ -0,0 +1,90 @@
+package report
+
+import (
+	"sync"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+// Point is our equity, marked to market, at the close of a candle
+type Point struct {
+	Time     time.Time `json:"time"`
+	Asset    float64   `json:"asset"`
+	Currency float64   `json:"currency"`
+	Price    float64   `json:"price"`
+	Equity   float64   `json:"equity"`
+}
+
+// Entry is an order being placed, (partially) filled, or cancelled
+type Entry struct {
+	Time    time.Time          `json:"time"`
+	OrderID string             `json:"order_id"`
+	Action  market.Action      `json:"action"`
+	Side    market.Action      `json:"side"`
+	Status  market.OrderStatus `json:"status"`
+	Price   float64            `json:"price"`
+	Size    float64            `json:"size"`
+	Fee     float64            `json:"fee"`
+}
+
+// IsFill checks if the entry is a fill
+func (e *Entry) IsFill() bool {
+	return e.Action == market.Buy || e.Action == market.Sell
+}
+
+// Recorder keeps track of our equity and orders while trading
+type Recorder struct {
+	sync.Mutex
+	market market.Market
+	Points []*Point
+	Ledger []*Entry
+}
+
+// NewRecorder -
+func NewRecorder(mrk market.Market) *Recorder {
+	return &Recorder{
+		market: mrk,
+		Points: []*Point{},
+		Ledger: []*Entry{},
+	}
+}
+
+// HandleCandle implements market.CandleHandler
+func (r *Recorder) HandleCandle(candle *market.Candle) error {
+	ast, cur, err := r.market.GetBalance()
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	// funds held by open orders are still ours
+	r.Points = append(r.Points, &Point{
+		Time:     candle.Time,
+		Asset:    ast.Total(),
+		Currency: cur.Total(),
+		Price:    candle.Close,
+		Equity:   cur.Total() + ast.Total()*candle.Close,
+	})
+	return nil
+}
+
+// HandleUpdate implements market.UpdateHandler
+func (r *Recorder) HandleUpdate(update *market.Update) error {
+	if update.Action == market.Hold {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.Ledger = append(r.Ledger, &Entry{
+		Time:    update.Time,
+		OrderID: update.OrderID,
+		Action:  update.Action,
+		Side:    update.Side,
+		Status:  update.Status,
+		Price:   update.Price,
+		Size:    update.Size,
+		Fee:     update.Fee,
+	})
+	return nil
+}
@@ -0,0 +1,232 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+const (
+	year = 365 * 24 * time.Hour
+	// dust is the smallest asset size we consider a position
+	dust = 1e-8
+)
+
+// Report summarizes the performance of a trading session
+type Report struct {
+	Start            time.Time     `json:"start"`
+	End              time.Time     `json:"end"`
+	Duration         time.Duration `json:"duration"`
+	StartEquity      float64       `json:"start_equity"`
+	EndEquity        float64       `json:"end_equity"`
+	Return           float64       `json:"return"`
+	AnnualizedReturn float64       `json:"annualized_return"`
+	MaxDrawdown      float64       `json:"max_drawdown"`
+	Sharpe           float64       `json:"sharpe"`
+	Sortino          float64       `json:"sortino"`
+	RoundTrips       int           `json:"round_trips"`
+	WinRate          float64       `json:"win_rate"`
+	AverageWin       float64       `json:"average_win"`
+	AverageLoss      float64       `json:"average_loss"`
+	Fees             float64       `json:"fees"`
+	Exposure         float64       `json:"exposure"`
+	BuyAndHold       float64       `json:"buy_and_hold"`
+}
+
+// lot is an amount of assets we bought at a price, including fees
+type lot struct {
+	size float64
+	cost float64
+}
+
+// Summarize creates a report from recorded equity points and ledger entries.
+// Round trips are made of the buys that opened a position and the run of
+// sells that closed it, with their profit calculated against the cost of the
+// first bought assets. Assets held at the start are valued at the first price.
+// Fills with no size are fee corrections, they are added to the last bought
+// lot or the closing trip, and to the fees in any case.
+func Summarize(points []*Point, ledger []*Entry) *Report {
+	rep := &Report{}
+	if len(points) == 0 {
+		return rep
+	}
+
+	first := points[0]
+	last := points[len(points)-1]
+	rep.Start = first.Time
+	rep.End = last.Time
+	rep.Duration = last.Time.Sub(first.Time)
+	rep.StartEquity = first.Equity
+	rep.EndEquity = last.Equity
+	if first.Equity > 0 {
+		rep.Return = last.Equity/first.Equity - 1
+	}
+	if rep.Duration > 0 && first.Equity > 0 && last.Equity > 0 {
+		rep.AnnualizedReturn = math.Pow(last.Equity/first.Equity, float64(year)/float64(rep.Duration)) - 1
+	}
+	if first.Price > 0 {
+		rep.BuyAndHold = last.Price/first.Price - 1
+	}
+
+	// drawdown and exposure
+	peak := first.Equity
+	exposed := 0.0
+	for i, p := range points {
+		if p.Equity > peak {
+			peak = p.Equity
+		}
+		if peak > 0 {
+			rep.MaxDrawdown = math.Max(rep.MaxDrawdown, (peak-p.Equity)/peak)
+		}
+		if i > 0 && points[i-1].Equity > 0 {
+			prev := points[i-1]
+			exposed += prev.Asset * prev.Price / prev.Equity * float64(p.Time.Sub(prev.Time))
+		}
+	}
+	if rep.Duration > 0 {
+		rep.Exposure = exposed / float64(rep.Duration)
+	}
+
+	// sharpe and sortino, annualized on the average period between points
+	returns := []float64{}
+	for i := 1; i < len(points); i++ {
+		if points[i-1].Equity > 0 {
+			returns = append(returns, points[i].Equity/points[i-1].Equity-1)
+		}
+	}
+	if len(returns) > 1 && rep.Duration > 0 {
+		periods := float64(year) / (float64(rep.Duration) / float64(len(returns)))
+		mean, dev, down := deviations(returns)
+		if dev > 0 {
+			rep.Sharpe = mean / dev * math.Sqrt(periods)
+		}
+		if down > 0 {
+			rep.Sortino = mean / down * math.Sqrt(periods)
+		}
+	}
+
+	// round trips
+	lots := []*lot{}
+	if first.Asset > dust {
+		lots = append(lots, &lot{size: first.Asset, cost: first.Asset * first.Price})
+	}
+	wins, losses := []float64{}, []float64{}
+	pnl := 0.0
+	closing := false
+	closeTrip := func() {
+		if !closing {
+			return
+		}
+		if pnl > 0 {
+			wins = append(wins, pnl)
+		} else {
+			losses = append(losses, pnl)
+		}
+		pnl = 0
+		closing = false
+	}
+	for _, f := range ledger {
+		if !f.IsFill() {
+			continue
+		}
+		rep.Fees += f.Fee
+		if f.Size == 0 {
+			switch {
+			case f.Action == market.Buy && len(lots) > 0:
+				lots[len(lots)-1].cost += f.Fee
+			case f.Action == market.Sell && closing:
+				pnl -= f.Fee
+			}
+			continue
+		}
+		switch f.Action {
+		case market.Buy:
+			closeTrip()
+			lots = append(lots, &lot{size: f.Size, cost: f.Size*f.Price + f.Fee})
+		case market.Sell:
+			closing = true
+			pnl += f.Size*f.Price - f.Fee
+			size := f.Size
+			for size > dust && len(lots) > 0 {
+				l := lots[0]
+				used := math.Min(size, l.size)
+				cost := l.cost * used / l.size
+				pnl -= cost
+				l.cost -= cost
+				l.size -= used
+				size -= used
+				if l.size <= dust {
+					lots = lots[1:]
+				}
+			}
+		}
+	}
+	closeTrip()
+	rep.RoundTrips = len(wins) + len(losses)
+	if rep.RoundTrips > 0 {
+		rep.WinRate = float64(len(wins)) / float64(rep.RoundTrips)
+	}
+	rep.AverageWin = average(wins)
+	rep.AverageLoss = average(losses)
+
+	return rep
+}
+
+// deviations returns the mean, standard deviation, and downside deviation
+func deviations(values []float64) (mean, dev, down float64) {
+	mean = average(values)
+	for _, v := range values {
+		dev += (v - mean) * (v - mean)
+		if v < 0 {
+			down += v * v
+		}
+	}
+	dev = math.Sqrt(dev / float64(len(values)-1))
+	down = math.Sqrt(down / float64(len(values)))
+	return mean, dev, down
+}
+
+func average(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Print writes the report as a table
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rows := [][]string{
+		{"Start", r.Start.Format(time.RFC3339)},
+		{"End", r.End.Format(time.RFC3339)},
+		{"Duration", r.Duration.String()},
+		{"Start equity", fmt.Sprintf("%0.2f", r.StartEquity)},
+		{"End equity", fmt.Sprintf("%0.2f", r.EndEquity)},
+		{"Return", fmt.Sprintf("%0.2f%%", r.Return*100)},
+		{"Annualized return", fmt.Sprintf("%0.2f%%", r.AnnualizedReturn*100)},
+		{"Buy and hold", fmt.Sprintf("%0.2f%%", r.BuyAndHold*100)},
+		{"Max drawdown", fmt.Sprintf("%0.2f%%", r.MaxDrawdown*100)},
+		{"Sharpe", fmt.Sprintf("%0.2f", r.Sharpe)},
+		{"Sortino", fmt.Sprintf("%0.2f", r.Sortino)},
+		{"Round trips", fmt.Sprintf("%d", r.RoundTrips)},
+		{"Win rate", fmt.Sprintf("%0.2f%%", r.WinRate*100)},
+		{"Average win", fmt.Sprintf("%0.2f", r.AverageWin)},
+		{"Average loss", fmt.Sprintf("%0.2f", r.AverageLoss)},
+		{"Fees", fmt.Sprintf("%0.2f", r.Fees)},
+		{"Exposure", fmt.Sprintf("%0.2f%%", r.Exposure*100)},
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1]); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
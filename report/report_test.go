@@ -0,0 +1,116 @@
+package report
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	market "github.com/geoah/go-trade/market"
+)
+
+func TestSummarize(t *testing.T) {
+	start := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	points := func(equity ...float64) []*Point {
+		ps := []*Point{}
+		for i, e := range equity {
+			ps = append(ps, &Point{
+				Time:     start.Add(time.Duration(i) * time.Hour),
+				Currency: e,
+				Price:    100,
+				Equity:   e,
+			})
+		}
+		return ps
+	}
+	fill := func(action market.Action, size, price, fee float64) *Entry {
+		return &Entry{
+			Action: action,
+			Side:   action,
+			Size:   size,
+			Price:  price,
+			Fee:    fee,
+		}
+	}
+	tests := []struct {
+		name     string
+		points   []*Point
+		ledger   []*Entry
+		ret      float64
+		drawdown float64
+		trips    int
+		winRate  float64
+		win      float64
+		loss     float64
+		fees     float64
+	}{
+		{
+			name:   "no points",
+			points: []*Point{},
+		},
+		{
+			name:     "equity only",
+			points:   points(100, 120, 90, 110),
+			ret:      0.1,
+			drawdown: 0.25,
+		},
+		{
+			name:   "win and loss",
+			points: points(100, 100),
+			ledger: []*Entry{
+				fill(market.Buy, 1, 10, 0.1),
+				{Action: market.Open, Size: 1, Price: 20},
+				fill(market.Sell, 0.5, 20, 0.1),
+				fill(market.Sell, 0.5, 20, 0.1),
+				fill(market.Buy, 1, 10, 0),
+				fill(market.Sell, 1, 8, 0),
+			},
+			trips:   2,
+			winRate: 0.5,
+			win:     9.7,
+			loss:    -2,
+			fees:    0.3,
+		},
+		{
+			name:   "fee corrections",
+			points: points(100, 100),
+			ledger: []*Entry{
+				fill(market.Buy, 1, 10, 0),
+				fill(market.Buy, 0, 10, 0.1),
+				fill(market.Sell, 1, 20, 0),
+				fill(market.Sell, 0, 20, 0.2),
+			},
+			trips:   1,
+			winRate: 1,
+			win:     9.7,
+			fees:    0.3,
+		},
+		{
+			name:   "fee correction without a position",
+			points: points(100, 100),
+			ledger: []*Entry{
+				fill(market.Buy, 0, 10, 0.1),
+			},
+			fees: 0.1,
+		},
+	}
+	for _, test := range tests {
+		rep := Summarize(test.points, test.ledger)
+		checks := []struct {
+			name     string
+			got, exp float64
+		}{
+			{"return", rep.Return, test.ret},
+			{"max drawdown", rep.MaxDrawdown, test.drawdown},
+			{"round trips", float64(rep.RoundTrips), float64(test.trips)},
+			{"win rate", rep.WinRate, test.winRate},
+			{"average win", rep.AverageWin, test.win},
+			{"average loss", rep.AverageLoss, test.loss},
+			{"fees", rep.Fees, test.fees},
+		}
+		for _, c := range checks {
+			if math.IsNaN(c.got) || math.Abs(c.got-c.exp) > 1e-9 {
+				t.Errorf("%s: expected %s %v, got %v", test.name, c.name, c.exp, c.got)
+			}
+		}
+	}
+}
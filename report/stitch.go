@@ -0,0 +1,42 @@
+package report
+
+// Stitch joins the equity curves and ledgers of consecutive sessions as if
+// they were a single one, by scaling each session so that it starts with the
+// equity the previous one ended with
+func Stitch(recorders ...*Recorder) ([]*Point, []*Entry) {
+	points := []*Point{}
+	ledger := []*Entry{}
+	equity := 0.0
+	for _, r := range recorders {
+		if len(r.Points) == 0 {
+			continue
+		}
+		scale := 1.0
+		if equity > 0 && r.Points[0].Equity > 0 {
+			scale = equity / r.Points[0].Equity
+		}
+		for _, p := range r.Points {
+			points = append(points, &Point{
+				Time:     p.Time,
+				Asset:    p.Asset * scale,
+				Currency: p.Currency * scale,
+				Price:    p.Price,
+				Equity:   p.Equity * scale,
+			})
+		}
+		for _, e := range r.Ledger {
+			ledger = append(ledger, &Entry{
+				Time:    e.Time,
+				OrderID: e.OrderID,
+				Action:  e.Action,
+				Side:    e.Side,
+				Status:  e.Status,
+				Price:   e.Price,
+				Size:    e.Size * scale,
+				Fee:     e.Fee * scale,
+			})
+		}
+		equity = points[len(points)-1].Equity
+	}
+	return points, ledger
+}
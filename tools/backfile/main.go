@@ -6,7 +6,7 @@ import (
 	"os"
 	"time"
 
-	exchange "github.com/preichenberger/go-coinbase-exchange"
+	gdax "github.com/geoah/go-trade/market/gdax"
 )
 
 func gf(fp string) *os.File {
@@ -23,7 +23,7 @@ func main() {
 	key := os.Getenv("COINBASE_KEY")
 	passphrase := os.Getenv("COINBASE_PASSPHRASE")
 
-	client := exchange.NewClient(secret, key, passphrase)
+	client := gdax.NewClient(secret, key, passphrase)
 
 	diff := -1 * time.Minute
 	end := time.Now().Add(time.Hour * -24).Round(time.Hour * 24)
@@ -39,16 +39,11 @@ func main() {
 			f = gf(edt)
 		}
 		start := end.Add(diff)
-		params := exchange.GetHistoricRatesParams{
-			Start:       start,
-			End:         end,
-			Granularity: 1,
-		}
-		rates, err := client.GetHistoricRates(product, params)
+		// the client waits for the rate limit and retries on its own
+		rates, err := client.GetHistoricRates(product, start, end, 1)
 		if err != nil {
-			// fmt.Println("err", err)
-			time.Sleep(250 * time.Millisecond)
-			continue
+			fmt.Println("Could not get rates", err)
+			os.Exit(1)
 		}
 		for _, rate := range rates {
 			// fmt.Println(trade.TradeId)
@@ -62,7 +57,6 @@ func main() {
 		fmt.Println(start.UTC().Format("2006-01-02T15:04:05-0700"), end.UTC().Format("2006-01-02T15:04:05-0700"), len(rates))
 
 		end = start
-		time.Sleep(275 * time.Millisecond)
 	}
 	// var trades []exchange.Trade
 	// cursor := client.ListTrades("ETH-BTC")
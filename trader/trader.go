@@ -1,6 +1,8 @@
 package trader
 
 import (
+	"sync"
+
 	"github.com/sirupsen/logrus"
 
 	market "github.com/geoah/go-trade/market"
@@ -10,21 +12,29 @@ import (
 
 // Trader -
 type Trader struct {
+	sync.Mutex
 	strategy strategy.Strategy
 	market   market.Market
 
+	// orders are the open orders we have placed by id, the account's other
+	// orders are left alone
+	orders map[string]*market.Order
+
 	assetRounding    int
 	currencyRounding int
 
 	Candles []*market.Candle
 	Trades  int
+	// Fees paid in currency
+	Fees float64
 }
 
 // New trader
-func New(market market.Market, strategy strategy.Strategy, assetRounding, currencyRounding int) (*Trader, error) {
+func New(mrk market.Market, strategy strategy.Strategy, assetRounding, currencyRounding int) (*Trader, error) {
 	return &Trader{
 		strategy:         strategy,
-		market:           market,
+		market:           mrk,
+		orders:           map[string]*market.Order{},
 		assetRounding:    assetRounding,
 		currencyRounding: currencyRounding,
 	}, nil
@@ -32,6 +42,25 @@ func New(market market.Market, strategy strategy.Strategy, assetRounding, curren
 
 // HandleUpdate -
 func (t *Trader) HandleUpdate(update *market.Update) error {
+	// only our own orders count, the account might have others
+	t.Lock()
+	_, tracked := t.orders[update.OrderID]
+	if tracked {
+		if update.Order != nil {
+			t.orders[update.OrderID] = update.Order
+			if update.Order.Done() {
+				delete(t.orders, update.OrderID)
+			}
+		}
+		if update.Action == market.Buy || update.Action == market.Sell {
+			t.Fees += update.Fee
+		}
+	}
+	t.Unlock()
+	if !tracked {
+		return nil
+	}
+
 	ast, cur, err := t.market.GetBalance()
 	if err != nil {
 		logrus.WithError(err).Warnf("Could not get balance")
@@ -39,10 +68,12 @@ func (t *Trader) HandleUpdate(update *market.Update) error {
 	}
 
 	tlog := logrus.
-		WithField("AST", ast).
-		WithField("CUR", cur).
+		WithField("AST", ast.Total()).
+		WithField("CUR", cur.Total()).
+		WithField("Status", update.Status).
 		WithField("Size", update.Size).
-		WithField("Price", update.Price)
+		WithField("Price", update.Price).
+		WithField("Fee", update.Fee)
 
 	switch update.Action {
 	case market.Buy:
@@ -78,15 +109,22 @@ func (t *Trader) HandleCandle(candle *market.Candle) error {
 		logrus.
 			WithField("ACT", "Buy").
 			Debugf("Strategy says")
+		t.cancelStale(market.Sell)
 		// act = "BUY"
 		// get market price
-		prc := candle.Close
-		// figure how much can we buy
+		prc := t.quote(market.Buy, candle)
+		// move our resting buys instead of placing more
+		if t.requote(market.Buy, utils.TrimFloat64(prc, t.currencyRounding)) {
+			return nil
+		}
+		// figure how much can we buy, orders that are still resting
+		// hold some of our currency
 		_, cur, _ := t.market.GetBalance()
-		// max assets we can buy
-		// limit currency a bit
+		// max assets we can buy, leaving room for the fees of a maker
+		// fill
 		// TODO Make configurable
-		mas := cur / prc // * 0.5 // * 0.99
+		fee := t.market.Fees().Rate(market.Maker, candle.Time)
+		mas := cur.Available / (prc * (1 + fee)) // * 0.5 // * 0.99
 		// make sure we have enough currency to buy with
 		if utils.TrimFloat64(mas, 5) == 0 {
 			// nevermind
@@ -99,11 +137,12 @@ func (t *Trader) HandleCandle(candle *market.Candle) error {
 			return nil
 		}
 		prc = utils.TrimFloat64(prc, t.currencyRounding)
-		err = t.market.Buy(qnt, prc)
+		ord, err := t.market.PlaceOrder(market.NewPostOnlyRequest(market.Buy, qnt, prc))
 		if err != nil {
 			logrus.WithError(err).Warnf("Could not buy assets")
 			return nil
 		}
+		t.track(ord)
 		candle.Event = &market.Event{
 			Action: string(market.Buy),
 		}
@@ -113,28 +152,34 @@ func (t *Trader) HandleCandle(candle *market.Candle) error {
 		logrus.
 			WithField("ACT", "Sell").
 			Debugf("Strategy says")
+		t.cancelStale(market.Buy)
 		// act = "SEL"
 		// get market price
-		prc := candle.Close
+		prc := t.quote(market.Sell, candle)
+		// move our resting sells instead of placing more
+		if t.requote(market.Sell, utils.TrimFloat64(prc, t.currencyRounding)) {
+			return nil
+		}
 		// max assets we can sell
 		// limit currency a bit
 		// TODO Make configurable
 		ast, _, _ := t.market.GetBalance()
-		mas := ast * 0.99
+		mas := ast.Available * 0.99
 		qnt = t.quantity(mas)
 		if qnt == 0.0 {
 			// logrus.Infof("Nil quantity")
 			return nil
 		}
 		prc = utils.TrimFloat64(prc, t.currencyRounding)
-		err = t.market.Sell(qnt, prc)
+		ord, err := t.market.PlaceOrder(market.NewPostOnlyRequest(market.Sell, qnt, prc))
 		if err != nil {
 			logrus.
 				WithError(err).
-				WithField("AST", ast).
+				WithField("AST", ast.Available).
 				Warnf("Could not sell assets")
 			return nil
 		}
+		t.track(ord)
 		candle.Event = &market.Event{
 			Action: string(market.Sell),
 		}
@@ -146,6 +191,87 @@ func (t *Trader) HandleCandle(candle *market.Candle) error {
 	return nil
 }
 
+// track remembers an order we have placed until it is done
+func (t *Trader) track(ord *market.Order) {
+	if ord.Done() {
+		return
+	}
+	t.Lock()
+	t.orders[ord.ID] = ord
+	t.Unlock()
+}
+
+// resting returns the open orders we have placed on the given side
+func (t *Trader) resting(side market.Action) []*market.Order {
+	t.Lock()
+	defer t.Unlock()
+	orders := []*market.Order{}
+	for _, o := range t.orders {
+		if o.Side == side {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+// cancelStale pulls the orders we have placed on the given side, they were
+// quoted off an older candle. We stop tracking them even if cancelling
+// fails, as they are either gone already or will run out of time.
+func (t *Trader) cancelStale(side market.Action) {
+	for _, o := range t.resting(side) {
+		t.Lock()
+		delete(t.orders, o.ID)
+		t.Unlock()
+		if err := t.market.CancelOrder(o.ID); err != nil {
+			logrus.WithError(err).WithField("order", o.ID).Warnf("Could not cancel order")
+		}
+	}
+}
+
+// requote amends the orders we have placed on the given side to the given
+// price, keeping what is left of them. Returns false if none of them are left,
+// eg. because every amend failed, so that a new order gets placed.
+func (t *Trader) requote(side market.Action, price float64) bool {
+	orders := t.resting(side)
+	for _, o := range orders {
+		if o.Price == price {
+			continue
+		}
+		t.Lock()
+		delete(t.orders, o.ID)
+		t.Unlock()
+		ord, err := t.market.AmendOrder(o.ID, o.Remaining(), price)
+		if err != nil {
+			logrus.WithError(err).WithField("order", o.ID).Warnf("Could not amend order")
+			continue
+		}
+		t.track(ord)
+	}
+	return len(t.resting(side)) > 0
+}
+
+// quote returns the price for a post only order on the given side, which is
+// the top of that side of the book so that we never cross the spread.
+// Falls back to the candle's close while the book is empty.
+func (t *Trader) quote(side market.Action, candle *market.Candle) float64 {
+	book := t.market.OrderBook()
+	if book == nil {
+		return candle.Close
+	}
+	var top market.BookLevel
+	var ok bool
+	switch side {
+	case market.Buy:
+		top, ok = book.BestBid()
+	case market.Sell:
+		top, ok = book.BestAsk()
+	}
+	if !ok {
+		return candle.Close
+	}
+	return top.Price
+}
+
 func (t *Trader) quantity(hardMax float64) float64 {
 	hardMin := 0.01
 	pct := 1.0 // 0.9